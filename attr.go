@@ -0,0 +1,185 @@
+package bpf
+
+import (
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// bpfPtr converts a pointer to the 64 bit representation the kernel expects in bpf_attr on every architecture.
+func bpfPtr(p unsafe.Pointer) uint64 {
+	return uint64(uintptr(p))
+}
+
+// bpfSyscall issues the bpf(2) command cmd with the passed attribute struct.
+func bpfSyscall(cmd Cmd, attr unsafe.Pointer, size uintptr) (uintptr, unix.Errno) {
+	r1, _, errno := unix.Syscall(unix.SYS_BPF, uintptr(cmd), uintptr(attr), size)
+
+	return r1, errno
+}
+
+// The structs below mirror the anonymous structs of union bpf_attr in include/uapi/linux/bpf.h, one per group of
+// commands. Pointers are __aligned_u64 in the kernel so they are uint64 here, and every hole is padded explicitly
+// so the layout is the same on 32 and 64 bit architectures. attr_test.go checks the sizes and offsets against the
+// uapi header.
+//
+// The kernel copies min(size, sizeof(union bpf_attr)) bytes and insists that any bytes it doesn't know about are
+// zero, so newer fields can be passed to older kernels as long as they are left unset.
+
+const bpfObjNameLen = 16
+
+// BPF_MAP_CREATE.
+type bpfMapCreateAttr struct {
+	mapType               uint32
+	keySize               uint32
+	valueSize             uint32
+	maxEntries            uint32
+	mapFlags              uint32
+	innerMapFd            uint32
+	numaNode              uint32
+	mapName               [bpfObjNameLen]byte
+	mapIfindex            uint32
+	btfFd                 uint32
+	btfKeyTypeID          uint32
+	btfValueTypeID        uint32
+	btfVmlinuxValueTypeID uint32
+	mapExtra              uint64
+}
+
+// BPF_MAP_LOOKUP_ELEM, BPF_MAP_UPDATE_ELEM, BPF_MAP_DELETE_ELEM and BPF_MAP_GET_NEXT_KEY. The kernel calls the
+// third field next_key for BPF_MAP_GET_NEXT_KEY.
+type bpfMapElemAttr struct {
+	mapFd uint32
+	_     uint32
+	key   uint64
+	value uint64
+	flags uint64
+}
+
+// BPF_MAP_LOOKUP_BATCH, BPF_MAP_LOOKUP_AND_DELETE_BATCH, BPF_MAP_UPDATE_BATCH and BPF_MAP_DELETE_BATCH.
+type bpfMapBatchAttr struct {
+	inBatch   uint64
+	outBatch  uint64
+	keys      uint64
+	values    uint64
+	count     uint32
+	mapFd     uint32
+	elemFlags uint64
+	flags     uint64
+}
+
+// BPF_PROG_LOAD.
+type bpfProgLoadAttr struct {
+	progType           uint32
+	insnCnt            uint32
+	insns              uint64
+	license            uint64
+	logLevel           uint32
+	logSize            uint32
+	logBuf             uint64
+	kernVersion        uint32
+	progFlags          uint32
+	progName           [bpfObjNameLen]byte
+	progIfindex        uint32
+	expectedAttachType uint32
+	progBtfFd          uint32
+	funcInfoRecSize    uint32
+	funcInfo           uint64
+	funcInfoCnt        uint32
+	lineInfoRecSize    uint32
+	lineInfo           uint64
+	lineInfoCnt        uint32
+	attachBtfID        uint32
+	attachProgFd       uint32
+	coreReloCnt        uint32
+	fdArray            uint64
+	coreRelos          uint64
+	coreReloRecSize    uint32
+	logTrueSize        uint32
+}
+
+// BPF_OBJ_PIN and BPF_OBJ_GET.
+type bpfObjAttr struct {
+	pathname  uint64
+	bpfFd     uint32
+	fileFlags uint32
+}
+
+// BPF_PROG_ATTACH and BPF_PROG_DETACH. Only the fields up to attach_flags are declared.
+type bpfProgAttachAttr struct {
+	targetFd    uint32
+	attachBpfFd uint32
+	attachType  uint32
+	attachFlags uint32
+}
+
+// BPF_PROG_QUERY. Only the fields up to prog_cnt are declared.
+type bpfProgQueryAttr struct {
+	targetFd    uint32
+	attachType  uint32
+	queryFlags  uint32
+	attachFlags uint32
+	progIDs     uint64
+	progCnt     uint32
+	_           uint32
+}
+
+// BPF_PROG_GET_FD_BY_ID and BPF_MAP_GET_FD_BY_ID. The kernel calls the first field prog_id or map_id.
+type bpfGetFDByIDAttr struct {
+	id        uint32
+	nextID    uint32
+	openFlags uint32
+}
+
+// BPF_OBJ_GET_INFO_BY_FD.
+type bpfObjInfoAttr struct {
+	bpfFd   uint32
+	infoLen uint32
+	info    uint64
+}
+
+// struct bpf_map_info, which BPF_OBJ_GET_INFO_BY_FD fills in for maps. Only the leading fields are declared; the
+// kernel copies no more than infoLen bytes.
+type bpfMapInfo struct {
+	mapType    uint32
+	id         uint32
+	keySize    uint32
+	valueSize  uint32
+	maxEntries uint32
+	mapFlags   uint32
+	name       [bpfObjNameLen]byte
+}
+
+// struct bpf_prog_info, which BPF_OBJ_GET_INFO_BY_FD fills in for programs. Only the leading fields are declared.
+type bpfProgInfo struct {
+	progType        uint32
+	id              uint32
+	tag             [8]byte
+	jitedProgLen    uint32
+	xlatedProgLen   uint32
+	jitedProgInsns  uint64
+	xlatedProgInsns uint64
+	loadTime        uint64
+	createdByUID    uint32
+	nrMapIDs        uint32
+	mapIDs          uint64
+	name            [bpfObjNameLen]byte
+}
+
+// bpfObjName converts name into the NUL terminated form used for map_name and prog_name. The kernel only accepts
+// alphanumerics, '_' and '.', so anything else is replaced with '_' and the name is cut to 15 characters.
+func bpfObjName(name string) [bpfObjNameLen]byte {
+	var objName [bpfObjNameLen]byte
+
+	for i := 0; i < len(name) && i < bpfObjNameLen-1; i++ {
+		c := name[i]
+		switch {
+		case c >= 'a' && c <= 'z', c >= 'A' && c <= 'Z', c >= '0' && c <= '9', c == '_', c == '.':
+			objName[i] = c
+		default:
+			objName[i] = '_'
+		}
+	}
+
+	return objName
+}
@@ -0,0 +1,213 @@
+package bpf
+
+import (
+	"testing"
+	"unsafe"
+)
+
+// The sizes and offsets below are taken from union bpf_attr in include/uapi/linux/bpf.h.
+
+func TestMapCreateAttrLayout(t *testing.T) {
+	var attr bpfMapCreateAttr
+
+	checkAttrLayout(t, "map_create", unsafe.Sizeof(attr), 72, []attrField{
+		{"map_type", unsafe.Offsetof(attr.mapType), 0},
+		{"key_size", unsafe.Offsetof(attr.keySize), 4},
+		{"value_size", unsafe.Offsetof(attr.valueSize), 8},
+		{"max_entries", unsafe.Offsetof(attr.maxEntries), 12},
+		{"map_flags", unsafe.Offsetof(attr.mapFlags), 16},
+		{"inner_map_fd", unsafe.Offsetof(attr.innerMapFd), 20},
+		{"numa_node", unsafe.Offsetof(attr.numaNode), 24},
+		{"map_name", unsafe.Offsetof(attr.mapName), 28},
+		{"map_ifindex", unsafe.Offsetof(attr.mapIfindex), 44},
+		{"btf_fd", unsafe.Offsetof(attr.btfFd), 48},
+		{"btf_key_type_id", unsafe.Offsetof(attr.btfKeyTypeID), 52},
+		{"btf_value_type_id", unsafe.Offsetof(attr.btfValueTypeID), 56},
+		{"btf_vmlinux_value_type_id", unsafe.Offsetof(attr.btfVmlinuxValueTypeID), 60},
+		{"map_extra", unsafe.Offsetof(attr.mapExtra), 64},
+	})
+}
+
+func TestMapElemAttrLayout(t *testing.T) {
+	var attr bpfMapElemAttr
+
+	checkAttrLayout(t, "map_elem", unsafe.Sizeof(attr), 32, []attrField{
+		{"map_fd", unsafe.Offsetof(attr.mapFd), 0},
+		{"key", unsafe.Offsetof(attr.key), 8},
+		{"value", unsafe.Offsetof(attr.value), 16},
+		{"flags", unsafe.Offsetof(attr.flags), 24},
+	})
+}
+
+func TestMapBatchAttrLayout(t *testing.T) {
+	var attr bpfMapBatchAttr
+
+	checkAttrLayout(t, "batch", unsafe.Sizeof(attr), 56, []attrField{
+		{"in_batch", unsafe.Offsetof(attr.inBatch), 0},
+		{"out_batch", unsafe.Offsetof(attr.outBatch), 8},
+		{"keys", unsafe.Offsetof(attr.keys), 16},
+		{"values", unsafe.Offsetof(attr.values), 24},
+		{"count", unsafe.Offsetof(attr.count), 32},
+		{"map_fd", unsafe.Offsetof(attr.mapFd), 36},
+		{"elem_flags", unsafe.Offsetof(attr.elemFlags), 40},
+		{"flags", unsafe.Offsetof(attr.flags), 48},
+	})
+}
+
+func TestProgLoadAttrLayout(t *testing.T) {
+	var attr bpfProgLoadAttr
+
+	checkAttrLayout(t, "prog_load", unsafe.Sizeof(attr), 144, []attrField{
+		{"prog_type", unsafe.Offsetof(attr.progType), 0},
+		{"insn_cnt", unsafe.Offsetof(attr.insnCnt), 4},
+		{"insns", unsafe.Offsetof(attr.insns), 8},
+		{"license", unsafe.Offsetof(attr.license), 16},
+		{"log_level", unsafe.Offsetof(attr.logLevel), 24},
+		{"log_size", unsafe.Offsetof(attr.logSize), 28},
+		{"log_buf", unsafe.Offsetof(attr.logBuf), 32},
+		{"kern_version", unsafe.Offsetof(attr.kernVersion), 40},
+		{"prog_flags", unsafe.Offsetof(attr.progFlags), 44},
+		{"prog_name", unsafe.Offsetof(attr.progName), 48},
+		{"prog_ifindex", unsafe.Offsetof(attr.progIfindex), 64},
+		{"expected_attach_type", unsafe.Offsetof(attr.expectedAttachType), 68},
+		{"prog_btf_fd", unsafe.Offsetof(attr.progBtfFd), 72},
+		{"func_info_rec_size", unsafe.Offsetof(attr.funcInfoRecSize), 76},
+		{"func_info", unsafe.Offsetof(attr.funcInfo), 80},
+		{"func_info_cnt", unsafe.Offsetof(attr.funcInfoCnt), 88},
+		{"line_info_rec_size", unsafe.Offsetof(attr.lineInfoRecSize), 92},
+		{"line_info", unsafe.Offsetof(attr.lineInfo), 96},
+		{"line_info_cnt", unsafe.Offsetof(attr.lineInfoCnt), 104},
+		{"attach_btf_id", unsafe.Offsetof(attr.attachBtfID), 108},
+		{"attach_prog_fd", unsafe.Offsetof(attr.attachProgFd), 112},
+		{"core_relo_cnt", unsafe.Offsetof(attr.coreReloCnt), 116},
+		{"fd_array", unsafe.Offsetof(attr.fdArray), 120},
+		{"core_relos", unsafe.Offsetof(attr.coreRelos), 128},
+		{"core_relo_rec_size", unsafe.Offsetof(attr.coreReloRecSize), 136},
+		{"log_true_size", unsafe.Offsetof(attr.logTrueSize), 140},
+	})
+}
+
+func TestObjAttrLayout(t *testing.T) {
+	var attr bpfObjAttr
+
+	checkAttrLayout(t, "obj", unsafe.Sizeof(attr), 16, []attrField{
+		{"pathname", unsafe.Offsetof(attr.pathname), 0},
+		{"bpf_fd", unsafe.Offsetof(attr.bpfFd), 8},
+		{"file_flags", unsafe.Offsetof(attr.fileFlags), 12},
+	})
+}
+
+func TestProgAttachAttrLayout(t *testing.T) {
+	var attr bpfProgAttachAttr
+
+	checkAttrLayout(t, "prog_attach", unsafe.Sizeof(attr), 16, []attrField{
+		{"target_fd", unsafe.Offsetof(attr.targetFd), 0},
+		{"attach_bpf_fd", unsafe.Offsetof(attr.attachBpfFd), 4},
+		{"attach_type", unsafe.Offsetof(attr.attachType), 8},
+		{"attach_flags", unsafe.Offsetof(attr.attachFlags), 12},
+	})
+
+	var query bpfProgQueryAttr
+
+	checkAttrLayout(t, "query", unsafe.Sizeof(query), 32, []attrField{
+		{"target_fd", unsafe.Offsetof(query.targetFd), 0},
+		{"attach_type", unsafe.Offsetof(query.attachType), 4},
+		{"query_flags", unsafe.Offsetof(query.queryFlags), 8},
+		{"attach_flags", unsafe.Offsetof(query.attachFlags), 12},
+		{"prog_ids", unsafe.Offsetof(query.progIDs), 16},
+		{"prog_cnt", unsafe.Offsetof(query.progCnt), 24},
+	})
+}
+
+func TestGetFDByIDAttrLayout(t *testing.T) {
+	var attr bpfGetFDByIDAttr
+
+	checkAttrLayout(t, "get_fd_by_id", unsafe.Sizeof(attr), 12, []attrField{
+		{"map_id", unsafe.Offsetof(attr.id), 0},
+		{"next_id", unsafe.Offsetof(attr.nextID), 4},
+		{"open_flags", unsafe.Offsetof(attr.openFlags), 8},
+	})
+}
+
+func TestObjInfoAttrLayout(t *testing.T) {
+	var attr bpfObjInfoAttr
+
+	checkAttrLayout(t, "info", unsafe.Sizeof(attr), 16, []attrField{
+		{"bpf_fd", unsafe.Offsetof(attr.bpfFd), 0},
+		{"info_len", unsafe.Offsetof(attr.infoLen), 4},
+		{"info", unsafe.Offsetof(attr.info), 8},
+	})
+
+	var info bpfMapInfo
+
+	checkAttrLayout(t, "bpf_map_info", unsafe.Sizeof(info), 40, []attrField{
+		{"type", unsafe.Offsetof(info.mapType), 0},
+		{"id", unsafe.Offsetof(info.id), 4},
+		{"key_size", unsafe.Offsetof(info.keySize), 8},
+		{"value_size", unsafe.Offsetof(info.valueSize), 12},
+		{"max_entries", unsafe.Offsetof(info.maxEntries), 16},
+		{"map_flags", unsafe.Offsetof(info.mapFlags), 20},
+		{"name", unsafe.Offsetof(info.name), 24},
+	})
+}
+
+func TestProgInfoLayout(t *testing.T) {
+	var info bpfProgInfo
+
+	checkAttrLayout(t, "bpf_prog_info", unsafe.Sizeof(info), 80, []attrField{
+		{"type", unsafe.Offsetof(info.progType), 0},
+		{"id", unsafe.Offsetof(info.id), 4},
+		{"tag", unsafe.Offsetof(info.tag), 8},
+		{"jited_prog_len", unsafe.Offsetof(info.jitedProgLen), 16},
+		{"xlated_prog_len", unsafe.Offsetof(info.xlatedProgLen), 20},
+		{"jited_prog_insns", unsafe.Offsetof(info.jitedProgInsns), 24},
+		{"xlated_prog_insns", unsafe.Offsetof(info.xlatedProgInsns), 32},
+		{"load_time", unsafe.Offsetof(info.loadTime), 40},
+		{"created_by_uid", unsafe.Offsetof(info.createdByUID), 48},
+		{"nr_map_ids", unsafe.Offsetof(info.nrMapIDs), 52},
+		{"map_ids", unsafe.Offsetof(info.mapIDs), 56},
+		{"name", unsafe.Offsetof(info.name), 64},
+	})
+}
+
+type attrField struct {
+	name   string
+	offset uintptr
+	want   uintptr
+}
+
+func checkAttrLayout(t *testing.T, name string, size uintptr, wantSize uintptr, fields []attrField) {
+	t.Helper()
+
+	if size != wantSize {
+		t.Errorf("%s: size is %d, want %d", name, size, wantSize)
+	}
+	for _, f := range fields {
+		if f.offset != f.want {
+			t.Errorf("%s: %s is at offset %d, want %d", name, f.name, f.offset, f.want)
+		}
+	}
+}
+
+func TestObjName(t *testing.T) {
+	tests := []struct {
+		name string
+		want string
+	}{
+		{"map1", "map1"},
+		{"kprobe/sys_open", "kprobe_sys_open"},
+		{"a_very_long_program_name", "a_very_long_pro"},
+	}
+
+	for _, test := range tests {
+		objName := bpfObjName(test.name)
+		if objName[bpfObjNameLen-1] != 0 {
+			t.Errorf("%s: name isn't NUL terminated", test.name)
+		}
+
+		got := string(objName[:len(test.want)])
+		if got != test.want || objName[len(test.want)] != 0 {
+			t.Errorf("%s: got %q, want %q", test.name, objName, test.want)
+		}
+	}
+}
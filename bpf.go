@@ -8,25 +8,46 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"syscall"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
 	"unsafe"
 
 	"golang.org/x/sys/unix"
 )
 
-const bpfSysCallNum = 321 // x86_64.
 const bpfInsnLen = 8
-const bpfVerifierDebugBufLen = 462144
 
 const (
-	bpfCmdMapCreate     = iota
-	bpfCmdMapLookupElem = iota
-	bpfCmdMapUpdateElem = iota
-	bpfCmdMapDeleteElem = iota
-	bpfCmdMapGetNextKey = iota
-	bpfCmdProgLoad      = iota
-	bpfCmdObjPin        = iota
-	bpfCmdObjGet        = iota
+	bpfCmdMapCreate               Cmd = iota
+	bpfCmdMapLookupElem           Cmd = iota
+	bpfCmdMapUpdateElem           Cmd = iota
+	bpfCmdMapDeleteElem           Cmd = iota
+	bpfCmdMapGetNextKey           Cmd = iota
+	bpfCmdProgLoad                Cmd = iota
+	bpfCmdObjPin                  Cmd = iota
+	bpfCmdObjGet                  Cmd = iota
+	bpfCmdProgAttach              Cmd = iota
+	bpfCmdProgDetach              Cmd = iota
+	bpfCmdProgTestRun             Cmd = iota
+	bpfCmdProgGetNextID           Cmd = iota
+	bpfCmdMapGetNextID            Cmd = iota
+	bpfCmdProgGetFdByID           Cmd = iota
+	bpfCmdMapGetFdByID            Cmd = iota
+	bpfCmdObjGetInfoByFd          Cmd = iota
+	bpfCmdProgQuery               Cmd = iota
+	bpfCmdRawTracepointOpen       Cmd = iota
+	bpfCmdBtfLoad                 Cmd = iota
+	bpfCmdBtfGetFdByID            Cmd = iota
+	bpfCmdTaskFdQuery             Cmd = iota
+	bpfCmdMapLookupAndDeleteElem  Cmd = iota
+	bpfCmdMapFreeze               Cmd = iota
+	bpfCmdBtfGetNextID            Cmd = iota
+	bpfCmdMapLookupBatch          Cmd = iota
+	bpfCmdMapLookupAndDeleteBatch Cmd = iota
+	bpfCmdMapUpdateBatch          Cmd = iota
+	bpfCmdMapDeleteBatch          Cmd = iota
 )
 
 const (
@@ -37,6 +58,89 @@ const (
 	bpfProgTypeSchedAct     = iota
 	bpfProgTypeTracepoint   = iota
 	bpfProgTypeXdp          = iota
+	bpfProgTypePerfEvent    = iota
+	bpfProgTypeCgroupSkb    = iota
+	bpfProgTypeCgroupSock   = iota
+	bpfProgTypeLwtIn        = iota
+	bpfProgTypeLwtOut       = iota
+	bpfProgTypeLwtXmit      = iota
+	bpfProgTypeSockOps      = iota
+	bpfProgTypeSkSkb        = iota
+	bpfProgTypeCgroupDevice = iota
+)
+
+// ProgType is the eBPF program type passed to the kernel when a program is loaded.
+type ProgType uint32
+
+const (
+	ProgTypeUnspec       ProgType = bpfProgTypeUnspec
+	ProgTypeSocketFilter ProgType = bpfProgTypeSocketFilter
+	ProgTypeKprobe       ProgType = bpfProgTypeKprobe
+	ProgTypeSchedCls     ProgType = bpfProgTypeSchedCls
+	ProgTypeSchedAct     ProgType = bpfProgTypeSchedAct
+	ProgTypeTracepoint   ProgType = bpfProgTypeTracepoint
+	ProgTypeXdp          ProgType = bpfProgTypeXdp
+	ProgTypePerfEvent    ProgType = bpfProgTypePerfEvent
+	ProgTypeCgroupSkb    ProgType = bpfProgTypeCgroupSkb
+	ProgTypeCgroupSock   ProgType = bpfProgTypeCgroupSock
+	ProgTypeLwtIn        ProgType = bpfProgTypeLwtIn
+	ProgTypeLwtOut       ProgType = bpfProgTypeLwtOut
+	ProgTypeLwtXmit      ProgType = bpfProgTypeLwtXmit
+	ProgTypeSockOps      ProgType = bpfProgTypeSockOps
+	ProgTypeSkSkb        ProgType = bpfProgTypeSkSkb
+	ProgTypeCgroupDevice ProgType = bpfProgTypeCgroupDevice
+)
+
+// progTypeFromSection works out the program type from an ELF section name using the same conventions as
+// iproute2 and the kernel samples. Sections that don't match any convention are loaded as cls_bpf programs
+// since that is what this loader has always done.
+func progTypeFromSection(section string) ProgType {
+	switch {
+	case section == "classifier" || strings.HasPrefix(section, "classifier/"):
+		return ProgTypeSchedCls
+	case section == "action" || strings.HasPrefix(section, "action/"):
+		return ProgTypeSchedAct
+	case strings.HasPrefix(section, "xdp"):
+		return ProgTypeXdp
+	case strings.HasPrefix(section, "kprobe/"), strings.HasPrefix(section, "kretprobe/"):
+		return ProgTypeKprobe
+	case strings.HasPrefix(section, "tracepoint/"):
+		return ProgTypeTracepoint
+	case strings.HasPrefix(section, "socket"):
+		return ProgTypeSocketFilter
+	case strings.HasPrefix(section, "cgroup/skb"), strings.HasPrefix(section, "cgroup_skb/"):
+		return ProgTypeCgroupSkb
+	case section == "cgroup/sock", section == "cgroup/sock_create", strings.HasPrefix(section, "cgroup/post_bind"):
+		return ProgTypeCgroupSock
+	case strings.HasPrefix(section, "sockops"):
+		return ProgTypeSockOps
+	case strings.HasPrefix(section, "cgroup/dev"):
+		return ProgTypeCgroupDevice
+	}
+
+	return ProgTypeSchedCls
+}
+
+// MapType is the eBPF map type passed to the kernel when a map is created.
+type MapType uint32
+
+const (
+	MapTypeUnspec         MapType = bpfMapTypeUnspec
+	MapTypeHash           MapType = bpfMapTypeHash
+	MapTypeArray          MapType = bpfMapTypeArray
+	MapTypeProgArray      MapType = bpfMapTypeProgArray
+	MapTypePerfEventArray MapType = bpfMapTypePerfEventArray
+	MapTypePerCpuHash     MapType = bpfMapTypePerCpuHash
+	MapTypePerCpuArray    MapType = bpfMapTypePerCpuArray
+	MapTypeStackTrace     MapType = bpfMapTypeStackTrace
+	MapTypeCgroupArray    MapType = bpfMapTypeCgroupArray
+	MapTypeLruHash        MapType = bpfMapTypeLruHash
+	MapTypeLruPerCpuHash  MapType = bpfMapTypeLruPerCpuHash
+	MapTypeLpmTrie        MapType = bpfMapTypeLpmTrie
+	MapTypeArrayOfMaps    MapType = bpfMapTypeArrayOfMaps
+	MapTypeHashOfMaps     MapType = bpfMapTypeHashOfMaps
+	MapTypeDevMap         MapType = bpfMapTypeDevMap
+	MapTypeSockMap        MapType = bpfMapTypeSockMap
 )
 
 const (
@@ -66,14 +170,6 @@ type MapEntry interface {
 	GetDataPtr() uintptr
 }
 
-type bpfMapCreateAttr struct {
-	mapType    uint32
-	keySize    uint32
-	valueSize  uint32
-	maxEntries uint32
-	mapFlags   uint32
-}
-
 func BpfCreateMap(mapType uint32, keySize uint32, valueSize uint32, maxEntries uint32, mapFlags uint32) (int, error) {
 	attrs := bpfMapCreateAttr{}
 	attrs.mapType = mapType
@@ -82,171 +178,309 @@ func BpfCreateMap(mapType uint32, keySize uint32, valueSize uint32, maxEntries u
 	attrs.maxEntries = maxEntries
 	attrs.mapFlags = mapFlags
 
-	r1, _, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdMapCreate), uintptr(unsafe.Pointer(&attrs)),
-		uintptr(unsafe.Sizeof(attrs)))
-	if serr != 0 {
-		if serr == syscall.ENOMEM {
-			return -1, errors.New("syscall result errno=ENOMEM")
-		} else if serr == syscall.EPERM {
-			return -1, errors.New("syscall result errno=EPERM")
-		} else if serr == syscall.EINVAL {
-			return -1, errors.New("syscall result errno=EINVAL")
-		}
+	return bpfMapCreate(&attrs)
+}
 
-		// Other error of some kind.
-		return -1, errors.New("syscall result unknown")
+// bpfMapCreate creates a map from a fully built request. If the kernel predates map names (4.15) and rejects the
+// request because of map_name, it is retried without the name.
+func bpfMapCreate(attrs *bpfMapCreateAttr) (int, error) {
+	r1, serr := bpfSyscall(bpfCmdMapCreate, unsafe.Pointer(attrs), unsafe.Sizeof(*attrs))
+	if serr == unix.E2BIG && attrs.mapName[0] != 0 {
+		noName := *attrs
+		noName.mapName = [bpfObjNameLen]byte{}
+		r1, serr = bpfSyscall(bpfCmdMapCreate, unsafe.Pointer(&noName), unsafe.Sizeof(noName))
+	}
+	if serr != 0 {
+		return -1, newError(bpfCmdMapCreate, serr, -1)
 	}
 
 	return int(r1), nil
 }
 
-type bpfMapUpdateElemAttr struct {
-	fd    uint32
-	key   uintptr
-	value uintptr
-	flags uintptr
-}
+// Flags for BpfMapUpdateElem.
+const (
+	UpdateAny     = 0 // Create a new element or update an existing one (BPF_ANY).
+	UpdateNoExist = 1 // Create a new element only if it didn't exist (BPF_NOEXIST).
+	UpdateExist   = 2 // Update an existing element only (BPF_EXIST).
+	UpdateLock    = 4 // Update the value while holding the element's bpf_spin_lock (BPF_F_LOCK).
+)
 
-// BpfMapUpdateElem updates the position key in map fd with entry.
+// BpfMapUpdateElem updates the position key in map fd with entry. flags is UpdateAny, UpdateNoExist or
+// UpdateExist, optionally combined with UpdateLock. If UpdateNoExist is passed and key is already present, the
+// error matches ErrKeyExist; if UpdateExist is passed and key is missing, it matches ErrKeyNotExist.
 func BpfMapUpdateElem(fd int, key MapKey, entry MapEntry, flags uint32) (bool, error) {
-	attrs := bpfMapUpdateElemAttr{}
-	attrs.fd = uint32(fd)
-	attrs.key = key.GetDataPtr()
-	attrs.value = entry.GetDataPtr()
-
-	r1, _, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdMapUpdateElem), uintptr(unsafe.Pointer(&attrs)), uintptr(unsafe.Sizeof(attrs)))
-	ret := int64(r1)
-	if ret != 0 { // Error.
-		if serr == syscall.ENOENT {
-			return false, errors.New("syscall result errno=ENOENT")
-		} else if serr == syscall.EINVAL {
-			return false, errors.New("syscall result errno=EINVAL")
-		}
-
-		// Other error of some kind.
-		return false, fmt.Errorf("syscall result unknown: %s", serr)
+	err := bpfMapUpdateElem(fd, uint64(key.GetDataPtr()), uint64(entry.GetDataPtr()), flags)
+	if err != nil {
+		return false, err
 	}
 
 	return true, nil
 }
 
-type bpfMapLookupElemAttr struct {
-	fd    uint32
-	key   uintptr
-	value uintptr
-	flags uintptr
+// BpfMapLookupElem looks key up in map fd and stores the value in entry. entry must have room for the whole value,
+// which for per-CPU maps is one 8 byte aligned value per possible CPU (see PossibleCPUs).
+func BpfMapLookupElem(fd int, key MapKey, entry MapEntry) (bool, error) {
+	return bpfMapLookupElem(fd, uint64(key.GetDataPtr()), uint64(entry.GetDataPtr()))
 }
 
-func BpfMapLookupElem(fd int, key MapKey, entry MapEntry) (bool, error) {
-	attrs := bpfMapLookupElemAttr{}
-	attrs.fd = uint32(fd)
-	attrs.key = key.GetDataPtr()
-	attrs.value = entry.GetDataPtr()
+func BpfMapDeleteElem(fd int, key MapKey) (bool, error) {
+	return bpfMapDeleteElem(fd, uint64(key.GetDataPtr()))
+}
 
-	r1, _, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdMapLookupElem), uintptr(unsafe.Pointer(&attrs)), uintptr(unsafe.Sizeof(attrs)))
-	ret := int64(r1)
+// BpfMapGetNextKey gets the next key after 'key' and stores it in 'result'.
+// It returns true if there are more keys in the map, false if this is the last key
+// or if there is an error.
+func BpfMapGetNextKey(fd int, key MapKey, result MapKey) (bool, error) {
+	return bpfMapGetNextKey(fd, uint64(key.GetDataPtr()), uint64(result.GetDataPtr()))
+}
+
+// The functions below do the map element syscalls with key and value already converted with bpfPtr. Callers must
+// keep the buffers alive until they return.
+
+func bpfMapUpdateElem(fd int, key uint64, value uint64, flags uint32) error {
+	attrs := bpfMapElemAttr{}
+	attrs.mapFd = uint32(fd)
+	attrs.key = key
+	attrs.value = value
+	attrs.flags = uint64(flags)
+
+	_, serr := bpfSyscall(bpfCmdMapUpdateElem, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		return newError(bpfCmdMapUpdateElem, serr, fd)
+	}
+
+	return nil
+}
 
-	if ret != 0 { // Error.
-		if serr == syscall.ENOENT {
+func bpfMapLookupElem(fd int, key uint64, value uint64) (bool, error) {
+	attrs := bpfMapElemAttr{}
+	attrs.mapFd = uint32(fd)
+	attrs.key = key
+	attrs.value = value
+
+	_, serr := bpfSyscall(bpfCmdMapLookupElem, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		if serr == unix.ENOENT {
 			// Key not found. Not really an error.
 			return false, nil
 		}
 
-		return false, serr
+		return false, newError(bpfCmdMapLookupElem, serr, fd)
 	}
 
 	return true, nil
 }
 
-type bpfMapDeleteElemAttr struct {
-	fd    uint32
-	key   uintptr
-	value uintptr
-	flags uintptr
-}
-
-func BpfMapDeleteElem(fd int, key MapKey) (bool, error) {
-	attrs := bpfMapDeleteElemAttr{}
-	attrs.fd = uint32(fd)
-	attrs.key = key.GetDataPtr()
-
-	r1, _, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdMapDeleteElem), uintptr(unsafe.Pointer(&attrs)), uintptr(unsafe.Sizeof(attrs)))
-	ret := int64(r1)
+func bpfMapDeleteElem(fd int, key uint64) (bool, error) {
+	attrs := bpfMapElemAttr{}
+	attrs.mapFd = uint32(fd)
+	attrs.key = key
 
-	if ret != 0 { // Error.
-		if serr == syscall.ENOENT {
+	_, serr := bpfSyscall(bpfCmdMapDeleteElem, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		if serr == unix.ENOENT {
 			// Delete failed because the key was not present.
 			return false, nil
 		}
 
-		return false, serr
+		return false, newError(bpfCmdMapDeleteElem, serr, fd)
 	}
 
 	return true, nil
 }
 
-type bpfMapGetNextKeyAttr struct {
-	fd      uint32  // 4
-	key     uintptr // 8
-	nextKey uintptr // 8
-	flags   uintptr // 8
-	//pad     [48 - 28]byte
-}
-
-// BpfMapGetNextKey gets the next key after 'key' and stores it in 'result'.
-// It returns true if there are more keys in the map, false if this is the last key
-// or if there is an error.
-func BpfMapGetNextKey(fd int, key MapKey, result MapKey) (bool, error) {
-	attrs := bpfMapGetNextKeyAttr{}
-	attrs.fd = uint32(fd)
-	attrs.key = key.GetDataPtr()
-	attrs.nextKey = result.GetDataPtr()
-
-	r1, r2, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdMapGetNextKey), uintptr(unsafe.Pointer(&attrs)), uintptr(unsafe.Sizeof(attrs)))
+// bpfMapGetNextKey stores the key following key in nextKey. A zero key asks for the first key of the map.
+func bpfMapGetNextKey(fd int, key uint64, nextKey uint64) (bool, error) {
+	attrs := bpfMapElemAttr{}
+	attrs.mapFd = uint32(fd)
+	attrs.key = key
+	attrs.value = nextKey
 
+	_, serr := bpfSyscall(bpfCmdMapGetNextKey, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
 	if serr != 0 {
-		if serr == syscall.ENOENT {
+		if serr == unix.ENOENT {
 			// The key that was searched for was the last one in the map. The end.
 			return false, nil
-		} else if serr == syscall.EINVAL {
-			fmt.Println("BpfMapGetNextKey syscall failure EINVAL:", r1, r2, serr)
-			return false, serr
-		} else {
-			// Some other error happened.
-			fmt.Println("BpfMapGetNextKey syscall failure:", r1, r2, serr)
-			return false, serr
 		}
+
+		return false, newError(bpfCmdMapGetNextKey, serr, fd)
 	}
 
 	return true, nil
 }
 
-type bpfProgLoadAttr struct {
-	progType    uint32
-	insnCnt     uint32
-	insns       uintptr
-	license     uintptr
-	logLevel    uint32
-	logSize     uint32
-	logBuf      uintptr
-	kernVersion uint32
+// bpfProgSpec describes a program to load, apart from the verifier log settings.
+type bpfProgSpec struct {
+	name               string
+	progType           ProgType
+	expectedAttachType uint32
+	ifindex            uint32
+	kernVersion        uint32 // 0 means the running kernel's version for kprobe programs.
+	insns              []bpfInsn
+	license            []byte
+}
+
+// bpfProgLoadWithOptions loads spec with the verifier log configured by opts. See LoadOptions.LogOnFailure.
+func bpfProgLoadWithOptions(spec *bpfProgSpec, opts *LoadOptions) (int, *VerifierError) {
+	if !opts.LogOnFailure {
+		return bpfProgLoad(spec, opts.LogLevel, opts.LogSize)
+	}
+
+	fd, verr := bpfProgLoad(spec, LogLevelNone, 0)
+	if verr == nil {
+		return fd, nil
+	}
+
+	// The load failed so do it again, this time with the log on, to find out why.
+	logLevel := opts.LogLevel
+	if logLevel == LogLevelNone {
+		logLevel = LogLevelInstruction
+	}
+
+	return bpfProgLoad(spec, logLevel, opts.LogSize)
+}
+
+// bpfProgLoad loads spec into the kernel and returns the fd of the new program. If logLevel isn't LogLevelNone,
+// the verifier log is captured into a buffer of logSize bytes, which is grown and the load retried whenever the
+// kernel reports that the log didn't fit.
+func bpfProgLoad(spec *bpfProgSpec, logLevel LogLevel, logSize int) (int, *VerifierError) {
+	if logSize <= 0 {
+		logSize = bpfVerifierLogSizeDefault
+	}
+
+	progName := bpfObjName(spec.name)
+	expectedAttachType := spec.expectedAttachType
+
+	for {
+		var logBuf []byte
+
+		// Build up the request.
+		attrs := bpfProgLoadAttr{}
+		attrs.progType = uint32(spec.progType)
+		attrs.insnCnt = uint32(len(spec.insns))
+		attrs.insns = bpfPtr(unsafe.Pointer(&spec.insns[0]))
+		attrs.license = bpfPtr(unsafe.Pointer(&spec.license[0]))
+		attrs.progName = progName
+		attrs.progIfindex = spec.ifindex
+		attrs.expectedAttachType = expectedAttachType
+		attrs.kernVersion = spec.kernVersion
+		if attrs.kernVersion == 0 && spec.progType == ProgTypeKprobe {
+			attrs.kernVersion = kernelVersion()
+		}
+		if logLevel != LogLevelNone {
+			logBuf = make([]byte, logSize)
+			attrs.logLevel = uint32(logLevel)
+			attrs.logSize = uint32(len(logBuf))
+			attrs.logBuf = bpfPtr(unsafe.Pointer(&logBuf[0]))
+		}
+
+		r1, serr := bpfSyscall(bpfCmdProgLoad, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+		runtime.KeepAlive(spec)
+		runtime.KeepAlive(logBuf)
+		if serr == 0 {
+			return int(r1), nil
+		}
+
+		// Kernels before 4.17 don't know about expected_attach_type, and kernels before 4.15 don't know about
+		// prog_name either, and reject the request. Drop the newer field first. Programs that really need an
+		// expected attach type can't be loaded on those kernels anyway, and then fail on attach instead.
+		if serr == unix.E2BIG && expectedAttachType != 0 {
+			expectedAttachType = 0
+			continue
+		}
+		if serr == unix.E2BIG && progName[0] != 0 {
+			progName = [bpfObjNameLen]byte{}
+			continue
+		}
+
+		truncated := serr == unix.ENOSPC && logBuf != nil
+		if truncated && logSize < bpfVerifierLogSizeMax {
+			logSize *= 2
+			if logSize > bpfVerifierLogSizeMax {
+				logSize = bpfVerifierLogSizeMax
+			}
+			continue
+		}
+
+		return -1, newVerifierError(serr, logBuf, truncated)
+	}
 }
 
 // BpfLoadProg loads the BPF programs identified by section names from the passed ELF filename and creates any
-// required BPF maps.
-// On success, it returns a map from section name to Fd and a map from BPF map name to Fd and two nil error values.
-// On failure, it returns two nils and one of two errors. The first error contains the BPF verifier failure (if any)
-// and the second error contains the error result from the syscall or other.
-func BpfLoadProg(file string, sections []string, sectionNameToFd map[string]int, mapNameToFd map[string]int) (error, error) {
-	f, err := os.Open(file)
-	if err != nil {
-		return nil, err
+// required BPF maps. The program type of each section is worked out from the section name.
+// On success, it returns a Collection holding the loaded programs (by section name) and the maps (by name). The
+// caller owns the Collection and must Close it to release the fds.
+// On failure, it returns nil and an error. Any programs or maps created before the failure are released, and maps
+// it pinned are unpinned again; maps that were already pinned are left as they were. Programs
+// are loaded without a verifier log; the log is only captured, by loading again, if the kernel rejects a program.
+// Like tc, every iproute2 style tail call section (ID/KEY, see __section_tail in bpf_api.h) is loaded as well, even
+// if it isn't listed in sections, and put into slot KEY of the prog array map with id ID.
+func BpfLoadProg(file string, sections []string) (*Collection, error) {
+	return BpfLoadProgWithTypes(file, sections, nil)
+}
+
+// BpfLoadProgWithTypes is like BpfLoadProg but allows the program type of each section to be set explicitly via
+// progTypes. Sections missing from progTypes, or set to ProgTypeUnspec, get a type worked out from the section name.
+func BpfLoadProgWithTypes(file string, sections []string, progTypes map[string]ProgType) (*Collection, error) {
+	opts := LoadOptions{
+		ProgTypes:    progTypes,
+		LogLevel:     LogLevelInstruction,
+		LogOnFailure: true,
+	}
+
+	return BpfLoadProgWithOptions(file, sections, &opts)
+}
+
+// LoadOptions controls how BpfLoadProgWithOptions loads programs.
+type LoadOptions struct {
+	// ProgTypes sets the program type of sections explicitly. Sections missing from ProgTypes, or set to
+	// ProgTypeUnspec, get a type worked out from the section name.
+	ProgTypes map[string]ProgType
+
+	// AttachTypes sets the expected attach type of sections, which cgroup-sock programs run on bind need at load
+	// time. Sections missing from AttachTypes get one worked out from the section name, if it implies one.
+	AttachTypes map[string]AttachType
+
+	// LogLevel is the verifier log level. LogLevelNone loads without a log, in which case a VerifierError
+	// only carries the errno.
+	LogLevel LogLevel
+
+	// LogOnFailure loads programs with the verifier log off, which is faster, and only if a load fails loads
+	// the same instructions again with LogLevel (LogLevelInstruction if that is LogLevelNone) to capture the
+	// diagnostics for the VerifierError.
+	LogOnFailure bool
+
+	// LogSize is the initial size of the verifier log buffer in bytes. The buffer is grown and the load retried
+	// if the log doesn't fit. Zero picks a default.
+	LogSize int
+
+	// KernelVersion is passed to the kernel as the kern_version of the programs, which kernels before 5.0 check
+	// against their own LINUX_VERSION_CODE when loading kprobe programs. Zero means the u32 in the ELF "version"
+	// section if there is one, and otherwise the version of the running kernel for kprobe programs.
+	KernelVersion uint32
+
+	// PinPath is the bpffs mount point below which maps with pinning set in their bpf_elf_map are pinned, in
+	// the same layout as tc uses. Empty means wherever bpffs is mounted (see BpffsMountpoint), or /sys/fs/bpf
+	// if it isn't mounted.
+	PinPath string
+}
+
+// BpfLoadProgWithOptions is like BpfLoadProg but takes LoadOptions. A nil opts loads with the zero LoadOptions.
+func BpfLoadProgWithOptions(file string, sections []string, opts *LoadOptions) (*Collection, error) {
+	if opts == nil {
+		opts = &LoadOptions{}
 	}
 
-	elfF, err := elf.NewFile(f)
+	elfF, err := elf.Open(file)
 	if err != nil {
 		return nil, err
 	}
+	defer elfF.Close()
+
+	// The kernel interprets the instructions in the host's byte order.
+	if elfF.ByteOrder != nativeEndian {
+		return nil, fmt.Errorf("ELF file %s is %s but the host is %s", file, elfF.ByteOrder, nativeEndian)
+	}
 
 	// Extract the license section. This section is mandatory and is passed to the kernel.
 	license := elfF.Section("license")
@@ -258,6 +492,11 @@ func BpfLoadProg(file string, sections []string, sectionNameToFd map[string]int,
 		return nil, err
 	}
 
+	kernVersion, err := bpfElfKernVersion(elfF, opts)
+	if err != nil {
+		return nil, err
+	}
+
 	// Load the maps as defined in the "maps" ELF section.
 	maps, err := bpfLoadMapsData(elfF)
 	if err != nil {
@@ -265,75 +504,212 @@ func BpfLoadProg(file string, sections []string, sectionNameToFd map[string]int,
 		return nil, errors.New("Error loading map data")
 	}
 
+	mapNames, err := bpfLoadMapNames(elfF)
+	if err != nil {
+		return nil, err
+	}
+
+	pinPaths, err := bpfMapPinPaths(file, maps, mapNames, opts.PinPath)
+	if err != nil {
+		return nil, err
+	}
+
 	// Create the maps (via BPF syscalls) and return a slice with fds that will be indexed with the symbol
 	// value / size of bpfElfMap later when we do the relocations.
-	mapFds, err := bpfCreateMaps(maps)
+	mapFds, pinned, err := bpfCreateMaps(maps, mapNames, pinPaths)
 	if err != nil {
-		log.Println("Error creating maps:", err)
-		return nil, errors.New("Could not create BPF Maps")
+		return nil, err
 	}
 
-	for _, section := range sections {
-		insns, err := getBpfInsnsFromSection(elfF, section)
+	coll := newCollection()
+
+	// Maps this load pinned are unpinned again if it fails, or the next load would reuse them half set up.
+	fail := func(err error) (*Collection, error) {
+		coll.Close()
+		for _, path := range pinned {
+			BpfObjUnpin(path)
+		}
+
+		return nil, err
+	}
+
+	for i, m := range maps {
+		name, ok := mapNames[i]
+		if !ok {
+			name = fmt.Sprintf("map%d", i)
+		}
+
+		bpfMap := &Map{
+			Name:       name,
+			Section:    "maps",
+			Type:       MapType(m.Type),
+			KeySize:    m.SizeKey,
+			ValueSize:  m.SizeValue,
+			MaxEntries: m.MaxElem,
+			Flags:      m.Flags,
+			PinPath:    pinPaths[i],
+		}
+		bpfMap.setFD(mapFds[i])
+		coll.Maps[name] = bpfMap
+	}
+
+	// Tail calls must have the same program type as the program calling them, so tail call sections without an
+	// explicit type take the type of the first section loaded.
+	entryType := ProgTypeUnspec
+	for _, section := range bpfSectionsToLoad(elfF, sections) {
+		progType := opts.ProgTypes[section]
+		if progType == ProgTypeUnspec {
+			if _, _, ok := bpfParseTailCallSection(section); ok && entryType != ProgTypeUnspec {
+				progType = entryType
+			} else {
+				progType = progTypeFromSection(section)
+			}
+		}
+		if entryType == ProgTypeUnspec {
+			entryType = progType
+		}
+
+		prog, err := bpfLoadSection(elfF, section, progType, opts, licenseData, kernVersion, mapFds)
 		if err != nil {
-			return nil, err
+			return fail(err)
 		}
 
-		// Get the ELF relocation section associated with the section being loaded.
-		relocSection := getElfRelatedRelocSection(elfF, section)
-		if len(maps) > 0 && relocSection == nil {
-			// This program doesn't have a reloc section
-		} else {
-			// Do map relocations (if any).
-			if relocSection != nil {
-				relocs, err := getBpfRelocationsFromSection(elfF, relocSection)
-				if err != nil {
-					return nil, err
-				}
+		coll.Programs[section] = prog
+	}
 
-				err = doBpfMapRelocation(insns, relocs, mapFds)
-				if err != nil {
-					return nil, err
-				}
+	if err := bpfPopulateProgArrays(coll, maps, mapNames, mapFds); err != nil {
+		return fail(err)
+	}
 
-				// Build a map which goes from map_name to the fd. This will be used by the calling application to know what
-				// fds to use. Note this is slightly inefficient since it hits each symbol more than once. Ahh well.
-				for _, reloc := range relocs {
-					mapNameToFd[reloc.name] = mapFds[reloc.value]
-				}
+	return coll, nil
+}
+
+// bpfSectionsToLoad returns sections followed by every tail call section of the ELF file that isn't already
+// listed. Like tc, the loader always loads tail calls so that the prog arrays of the requested programs are
+// complete.
+func bpfSectionsToLoad(elfF *elf.File, sections []string) []string {
+	listed := make(map[string]bool, len(sections))
+	for _, section := range sections {
+		listed[section] = true
+	}
+
+	all := append([]string(nil), sections...)
+	for _, sec := range elfF.Sections {
+		if sec.Type != elf.SHT_PROGBITS || sec.Flags&elf.SHF_EXECINSTR == 0 || listed[sec.Name] {
+			continue
+		}
+		if _, _, ok := bpfParseTailCallSection(sec.Name); ok {
+			all = append(all, sec.Name)
+			listed[sec.Name] = true
+		}
+	}
+
+	return all
+}
+
+// bpfParseTailCallSection parses an iproute2 tail call section name of the form ID/KEY (see __section_tail in
+// bpf_api.h), where ID is the id of a prog array map and KEY the slot of the program in it.
+func bpfParseTailCallSection(section string) (id uint32, key uint32, ok bool) {
+	idStr, keyStr, found := strings.Cut(section, "/")
+	if !found {
+		return 0, 0, false
+	}
+
+	id64, err := strconv.ParseUint(idStr, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+	key64, err := strconv.ParseUint(keyStr, 10, 32)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return uint32(id64), uint32(key64), true
+}
+
+// bpfPopulateProgArrays inserts every program loaded from a tail call section into the prog array map whose id
+// matches the section.
+func bpfPopulateProgArrays(coll *Collection, maps []bpfElfMap, names map[int]string, mapFds []int) error {
+	for section, prog := range coll.Programs {
+		id, key, ok := bpfParseTailCallSection(section)
+		if !ok {
+			continue
+		}
+
+		idx := -1
+		for i, m := range maps {
+			if m.Type == bpfMapTypeProgArray && m.Id == id {
+				idx = i
+				break
 			}
 		}
+		if idx < 0 {
+			return fmt.Errorf("No prog array map with id %d for tail call section %s", id, section)
+		}
 
-		////
-		// Now that we've done all that setup work... let's do the syscall.
-		////
+		if err := fdArraySet(mapFds[idx], key, prog.fd); err != nil {
+			return withName(err, names[idx])
+		}
+	}
 
-		// Create a buffer to store errors from the in-kernel BPF verifier (if any).
-		var verifierErrBuf [bpfVerifierDebugBufLen]byte
+	return nil
+}
 
-		// Build up the request.
-		attrs := bpfProgLoadAttr{}
-		attrs.progType = bpfProgTypeSchedCls
-		attrs.insnCnt = uint32(len(insns))
-		attrs.insns = uintptr(unsafe.Pointer(&insns[0]))
-		attrs.license = uintptr(unsafe.Pointer(&licenseData[0]))
-		attrs.logLevel = 1 // Enables verifier logging.
-		attrs.logSize = uint32(len(verifierErrBuf))
-		attrs.logBuf = uintptr(unsafe.Pointer(&verifierErrBuf))
+// bpfLoadSection performs the map relocations for one section of the ELF file and loads it into the kernel as a
+// program of type progType.
+func bpfLoadSection(elfF *elf.File, section string, progType ProgType, opts *LoadOptions, licenseData []byte,
+	kernVersion uint32, mapFds []int) (*Program, error) {
+	insns, err := getBpfInsnsFromSection(elfF, section)
+	if err != nil {
+		return nil, err
+	}
 
-		r1, _, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdProgLoad), uintptr(unsafe.Pointer(&attrs)),
-			uintptr(unsafe.Sizeof(attrs)))
-		if serr != 0 {
-			e := fmt.Sprintf("Verifier error %d in section %s: %s", r1, section,
-				string(verifierErrBuf[:bpfVerifierDebugBufLen]))
-			verifierError := errors.New(e)
-			return verifierError, serr
+	// Do map relocations (if any) using the ELF relocation section associated with the section being loaded.
+	relocSection := getElfRelatedRelocSection(elfF, section)
+	if relocSection != nil {
+		relocs, err := getBpfRelocationsFromSection(elfF, relocSection)
+		if err != nil {
+			return nil, err
 		}
 
-		sectionNameToFd[section] = int(r1)
+		err = doBpfMapRelocation(insns, relocs, mapFds)
+		if err != nil {
+			return nil, err
+		}
 	}
 
-	return nil, nil
+	////
+	// Now that we've done all that setup work... let's do the syscall.
+	////
+
+	attachType, ok := opts.AttachTypes[section]
+	if !ok {
+		attachType = attachTypeFromSection(section)
+	}
+
+	spec := bpfProgSpec{
+		name:               bpfSectionFuncName(elfF, section),
+		progType:           progType,
+		expectedAttachType: uint32(attachType),
+		kernVersion:        kernVersion,
+		insns:              insns,
+		license:            licenseData,
+	}
+
+	fd, verr := bpfProgLoadWithOptions(&spec, opts)
+	if verr != nil {
+		verr.Section = section
+		return nil, verr
+	}
+
+	prog := &Program{
+		Name:    spec.name,
+		Section: section,
+		Type:    progType,
+	}
+	prog.setFD(fd)
+
+	return prog, nil
 }
 
 // See bpf_elf.h in iproute2. Strictly speaking, this loader doesn't have to use the same structure as iproute2
@@ -352,6 +728,9 @@ type bpfElfMap struct {
 
 const bpfElfMapLen = 36 // TODO: Use SizeOf instead?
 
+// bpfElfMapNoInnerIdx in InnerIDx keeps an inner map template out of the map-in-maps that use it.
+const bpfElfMapNoInnerIdx = 0xffffffff
+
 // bpfLoadMapsData extracts the details of the maps used in this eBPF program from the "maps" section
 // of the ELF file. If the "maps" section is not present, it returns an empty slice.
 func bpfLoadMapsData(elfF *elf.File) ([]bpfElfMap, error) {
@@ -373,7 +752,7 @@ func bpfLoadMapsData(elfF *elf.File) ([]bpfElfMap, error) {
 	}
 	buf := bytes.NewBuffer(data)
 
-	err = binary.Read(buf, binary.LittleEndian, &maps)
+	err = binary.Read(buf, elfF.ByteOrder, &maps)
 	if err != nil {
 		return nil, err
 	}
@@ -381,22 +760,183 @@ func bpfLoadMapsData(elfF *elf.File) ([]bpfElfMap, error) {
 	return maps, nil
 }
 
+// bpfLoadMapNames returns the symbol names of the maps defined in the "maps" section, indexed the same way as the
+// slice returned by bpfLoadMapsData.
+func bpfLoadMapNames(elfF *elf.File) (map[int]string, error) {
+	names := make(map[int]string)
+
+	mapsSection := elfF.Section("maps")
+	if mapsSection == nil {
+		return names, nil
+	}
+
+	syms, err := elfF.Symbols()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, sym := range syms {
+		if int(sym.Section) >= len(elfF.Sections) || elfF.Sections[sym.Section] != mapsSection {
+			continue
+		}
+		if elf.ST_TYPE(sym.Info) == elf.STT_SECTION || sym.Name == "" {
+			continue
+		}
+
+		names[int(sym.Value/bpfElfMapLen)] = sym.Name
+	}
+
+	return names, nil
+}
+
 // bpfCreateMaps takes in the map definitions as extracted from the "maps" section and creates the maps.
+// names gives the map names passed to the kernel and used in errors.
 // It returns a slice that maps the map index, as defined in the "maps" section to the file descriptor
 // that is created for that map.
-func bpfCreateMaps(maps []bpfElfMap) ([]int, error) {
-	fds := make([]int, 0, len(maps))
+//
+// Maps with a path in pinPaths are reused if a map is already pinned there, after checking that it matches the
+// definition, and pinned there once created otherwise. The paths of the maps it pinned are returned as well, for
+// the caller to unpin if loading fails later on.
+//
+// Map-in-map definitions (array or hash of maps) name the map serving as the template for their inner maps with
+// InnerID, so they are created after all the other maps. As in tc, a map whose id is used as an InnerID and whose
+// InnerIDx isn't bpfElfMapNoInnerIdx is then put into each new outer map referring to it at index InnerIDx.
+// If creating any map fails, the maps that were already created are closed and unpinned.
+func bpfCreateMaps(maps []bpfElfMap, names map[int]string, pinPaths []string) ([]int, []string, error) {
+	fds := make([]int, len(maps))
+	for i := range fds {
+		fds[i] = -1
+	}
+	reused := make([]bool, len(maps))
+	var pinned []string
 
-	for _, m := range maps {
-		fd, err := BpfCreateMap(m.Type, m.SizeKey, m.SizeValue, m.MaxElem, m.Flags)
-		if err != nil {
-			return nil, err
+	closeAll := func() {
+		for _, fd := range fds {
+			if fd >= 0 {
+				unix.Close(fd)
+			}
+		}
+		for _, path := range pinned {
+			os.Remove(path)
+		}
+	}
+
+	for _, outer := range []bool{false, true} {
+		for i, m := range maps {
+			if isMapInMap(MapType(m.Type)) != outer {
+				continue
+			}
+
+			if pinPaths[i] != "" {
+				fd, err := bpfReusePinnedMap(pinPaths[i], m, names[i])
+				if err != nil {
+					closeAll()
+					return nil, nil, err
+				}
+				if fd >= 0 {
+					fds[i] = fd
+					reused[i] = true
+					continue
+				}
+			}
+
+			attrs := bpfMapCreateAttr{}
+			attrs.mapType = m.Type
+			attrs.keySize = m.SizeKey
+			attrs.valueSize = m.SizeValue
+			attrs.maxEntries = m.MaxElem
+			attrs.mapFlags = m.Flags
+			attrs.mapName = bpfObjName(names[i])
+
+			if outer {
+				inner := bpfFindInnerMap(maps, m.InnerID)
+				if inner < 0 {
+					closeAll()
+					return nil, nil, fmt.Errorf("Map-in-map %s refers to inner map id %d, which isn't defined", names[i],
+						m.InnerID)
+				}
+				attrs.innerMapFd = uint32(fds[inner])
+			}
+
+			fd, err := bpfMapCreate(&attrs)
+			if err != nil {
+				closeAll()
+				return nil, nil, withName(err, names[i])
+			}
+			fds[i] = fd
+
+			if pinPaths[i] != "" {
+				if err := BpfObjPin(fd, pinPaths[i]); err != nil {
+					closeAll()
+					return nil, nil, withName(err, names[i])
+				}
+				pinned = append(pinned, pinPaths[i])
+			}
+		}
+	}
+
+	for i, m := range maps {
+		// Pinned outer maps that were reused keep the inner maps they already have.
+		if !isMapInMap(MapType(m.Type)) || reused[i] {
+			continue
+		}
+
+		inner := bpfFindInnerMap(maps, m.InnerID)
+		if maps[inner].InnerIDx == bpfElfMapNoInnerIdx {
+			continue
+		}
+
+		keyBuf := make([]byte, m.SizeKey)
+		if len(keyBuf) < 4 {
+			closeAll()
+			return nil, nil, fmt.Errorf("Map-in-map %s has %d byte keys, too small for index %d", names[i], m.SizeKey,
+				maps[inner].InnerIDx)
+		}
+		nativeEndian.PutUint32(keyBuf, maps[inner].InnerIDx)
+
+		if err := mapInMapSet(fds[i], keyBuf, fds[inner], UpdateAny); err != nil {
+			closeAll()
+			return nil, nil, withName(err, names[i])
+		}
+	}
+
+	return fds, pinned, nil
+}
+
+// bpfFindInnerMap returns the index of the map with id that can serve as the inner map template of a map-in-map,
+// or -1 if there isn't one.
+func bpfFindInnerMap(maps []bpfElfMap, id uint32) int {
+	if id == 0 {
+		return -1
+	}
+
+	for i, m := range maps {
+		if m.Id == id && !isMapInMap(MapType(m.Type)) {
+			return i
 		}
+	}
 
-		fds = append(fds, fd)
+	return -1
+}
+
+// bpfSectionFuncName returns the name of the function defined in section, falling back to the section name if
+// the symbol table doesn't have one.
+func bpfSectionFuncName(elfF *elf.File, section string) string {
+	syms, err := elfF.Symbols()
+	if err != nil {
+		return section
 	}
 
-	return fds, nil
+	for _, sym := range syms {
+		if elf.ST_TYPE(sym.Info) != elf.STT_FUNC || int(sym.Section) >= len(elfF.Sections) {
+			continue
+		}
+		if elfF.Sections[sym.Section].Name == section {
+			return sym.Name
+		}
+	}
+
+	return section
 }
 
 // getElfRelatedRelocSection returns the relocation section (SHT_REL) associated with the passed
@@ -449,7 +989,7 @@ func getBpfInsnsFromSection(f *elf.File, section string) ([]bpfInsn, error) {
 
 	insns := make([]bpfInsn, len(d)/bpfInsnLen)
 
-	err = binary.Read(buf, binary.LittleEndian, &insns)
+	err = binary.Read(buf, f.ByteOrder, &insns)
 	if err != nil {
 		return nil, err
 	}
@@ -470,7 +1010,7 @@ func getBpfRelocationsFromSection(f *elf.File, section *elf.Section) ([]bpfMapRe
 	bb := bytes.NewBuffer(d)
 	const rel64Len = 16
 	rel64s := make([]elf.Rel64, len(d)/rel64Len)
-	err := binary.Read(bb, binary.LittleEndian, &rel64s)
+	err := binary.Read(bb, f.ByteOrder, &rel64s)
 	if err != nil {
 		return nil, err
 	}
@@ -509,33 +1049,65 @@ func doBpfMapRelocation(insns []bpfInsn, relocs []bpfMapRelocation, mapFds []int
 	return nil
 }
 
-type bpfObjPinAttr struct {
-	pathname uintptr
-	fd       uint32
-}
-
-// FIXME: Pin doesn't work yet. Don't know why.
+// BpfObjPin pins the map or program fd to pathname, which must be on a bpf filesystem. Missing parent
+// directories are created. The pinned object stays alive until it is unpinned, even once every fd is closed.
 func BpfObjPin(fd int, pathname string) error {
-	attrs := bpfObjPinAttr{}
-	attrs.fd = uint32(fd)
+	// Check before creating anything, so a path outside bpffs doesn't leave directories behind.
+	dir := filepath.Dir(pathname)
+	if err := checkBpffs(nearestExistingDir(dir)); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
 
 	pptr, err := unix.BytePtrFromString(pathname)
 	if err != nil {
 		return err
 	}
 
-	attrs.pathname = uintptr(unsafe.Pointer(pptr))
+	attrs := bpfObjAttr{}
+	attrs.bpfFd = uint32(fd)
+	attrs.pathname = bpfPtr(unsafe.Pointer(pptr))
 
-	r1, r2, serr := unix.Syscall(bpfSysCallNum, uintptr(bpfCmdObjPin), uintptr(unsafe.Pointer(&attrs)), uintptr(unsafe.Sizeof(attrs)))
+	_, serr := bpfSyscall(bpfCmdObjPin, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(pptr)
 	if serr != 0 {
-		fmt.Println("BpfObjPin syscall failure", r1, r2, serr)
-		return serr
+		err := newError(bpfCmdObjPin, serr, fd)
+		err.Name = pathname
+		return err
 	}
 
 	return nil
 }
 
-// TODO: UnPin
-func BpfObjUnpin(fd int) error {
-	return nil
+// BpfObjGet opens the map or program pinned at pathname and returns a new fd referring to it.
+func BpfObjGet(pathname string) (int, error) {
+	pptr, err := unix.BytePtrFromString(pathname)
+	if err != nil {
+		return -1, err
+	}
+
+	attrs := bpfObjAttr{}
+	attrs.pathname = bpfPtr(unsafe.Pointer(pptr))
+
+	r1, serr := bpfSyscall(bpfCmdObjGet, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(pptr)
+	if serr != 0 {
+		err := newError(bpfCmdObjGet, serr, -1)
+		err.Name = pathname
+		return -1, err
+	}
+
+	return int(r1), nil
+}
+
+// BpfObjUnpin removes the pin at pathname, which must be on a bpf filesystem. The object is freed once nothing
+// else (an fd, an attachment, another pin) holds a reference to it.
+func BpfObjUnpin(pathname string) error {
+	if err := checkBpffs(filepath.Dir(pathname)); err != nil {
+		return err
+	}
+
+	return os.Remove(pathname)
 }
@@ -27,6 +27,9 @@
 #define PIN_OBJECT_NS		1
 #define PIN_GLOBAL_NS		2
 
+/* Map-in-map templates with this inner_idx aren't put into their outer maps */
+#define BPF_ELF_NO_INNER_IDX	0xffffffff
+
 /* ELF map definition */
 struct bpf_elf_map {
 	__u32 type;
@@ -36,6 +39,8 @@ struct bpf_elf_map {
 	__u32 flags;
 	__u32 id;
 	__u32 pinning;
+	__u32 inner_id;
+	__u32 inner_idx;
 };
 
 #endif /* __BPF_ELF__ */
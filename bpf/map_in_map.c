@@ -0,0 +1,48 @@
+#include <stdint.h>
+#include <asm/types.h>
+#include <linux/bpf.h>
+#include <linux/pkt_cls.h>
+
+#include "bpf_elf.h"
+#include "bpf_api.h"
+
+enum {
+	MAP_ID_OUTER = 1,
+	MAP_ID_INNER,
+};
+
+// Template for the inner maps, which is also put into slot 0 of the outer map.
+struct bpf_elf_map __section_maps inner_map = {
+	.type		=	BPF_MAP_TYPE_HASH,
+	.id		=	MAP_ID_INNER,
+	.size_key	=	sizeof(__u32),
+	.size_value	=	sizeof(__u64),
+	.max_elem	=	16,
+	.inner_idx	=	0,
+};
+
+struct bpf_elf_map __section_maps outer_map = {
+	.type		=	BPF_MAP_TYPE_ARRAY_OF_MAPS,
+	.id		=	MAP_ID_OUTER,
+	.size_key	=	sizeof(__u32),
+	.size_value	=	sizeof(__u32),
+	.max_elem	=	4,
+	.inner_id	=	MAP_ID_INNER,
+};
+
+__section_cls_entry
+int cls_main(struct __sk_buff *skb)
+{
+	__u32 tenant = 0;
+	void *inner;
+
+	inner = map_lookup_elem(&outer_map, &tenant);
+	if (!inner)
+		return TC_ACT_UNSPEC;
+
+	map_lookup_elem(inner, &tenant);
+
+	return TC_ACT_UNSPEC;
+}
+
+BPF_LICENSE("GPL");
@@ -0,0 +1,38 @@
+#include <stdint.h>
+#include <asm/types.h>
+#include <linux/bpf.h>
+#include <linux/pkt_cls.h>
+
+#include "bpf_elf.h"
+#include "bpf_api.h"
+
+// Shared with every object that has a map of the same name, like tc's globals.
+struct bpf_elf_map __section_maps pinned_global = {
+	.type		=	BPF_MAP_TYPE_ARRAY,
+	.size_key	=	sizeof(__u32),
+	.size_value	=	sizeof(__u64),
+	.max_elem	=	4,
+	.pinning	=	PIN_GLOBAL_NS,
+};
+
+// Shared by every load of this object file.
+struct bpf_elf_map __section_maps pinned_object = {
+	.type		=	BPF_MAP_TYPE_HASH,
+	.size_key	=	sizeof(__u32),
+	.size_value	=	sizeof(__u64),
+	.max_elem	=	16,
+	.pinning	=	PIN_OBJECT_NS,
+};
+
+__section_cls_entry
+int cls_main(struct __sk_buff *skb)
+{
+	__u32 key = 0;
+
+	map_lookup_elem(&pinned_global, &key);
+	map_lookup_elem(&pinned_object, &key);
+
+	return TC_ACT_UNSPEC;
+}
+
+BPF_LICENSE("GPL");
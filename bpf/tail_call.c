@@ -0,0 +1,49 @@
+#include <stdint.h>
+#include <asm/types.h>
+#include <linux/bpf.h>
+#include <linux/pkt_cls.h>
+
+#include "bpf_elf.h"
+#include "bpf_api.h"
+
+// The prog array id, which is also the first half of the tail call section names.
+#define JMP_MAP_ID	1
+
+enum {
+	JMP_STAGE0,
+	JMP_STAGE1,
+	__JMP_MAX,
+};
+
+struct bpf_elf_map __section_maps jmp_map = {
+	.type		=	BPF_MAP_TYPE_PROG_ARRAY,
+	.id		=	JMP_MAP_ID,
+	.size_key	=	sizeof(__u32),
+	.size_value	=	sizeof(__u32),
+	.max_elem	=	__JMP_MAX,
+};
+
+__section_tail(JMP_MAP_ID, 0)
+int cls_stage0(struct __sk_buff *skb)
+{
+	tail_call(skb, &jmp_map, JMP_STAGE1);
+
+	return TC_ACT_OK;
+}
+
+__section_tail(JMP_MAP_ID, 1)
+int cls_stage1(struct __sk_buff *skb)
+{
+	return TC_ACT_SHOT;
+}
+
+__section_cls_entry
+int cls_entry(struct __sk_buff *skb)
+{
+	tail_call(skb, &jmp_map, JMP_STAGE0);
+
+	// Only reached if the prog array slot is empty.
+	return TC_ACT_UNSPEC;
+}
+
+BPF_LICENSE("GPL");
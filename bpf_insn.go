@@ -44,7 +44,16 @@ type bpfInsn struct {
 	Imm    int32 // Signed immediate constant
 }
 
+// SetSrcReg sets the source register. The C bitfield puts dst_reg in the low nibble on little endian hosts and
+// in the high nibble on big endian hosts.
 func (insn *bpfInsn) SetSrcReg(value uint8) {
+	if nativeEndian == binary.BigEndian {
+		dstReg := insn.Regs & 0xF0
+
+		insn.Regs = dstReg | (value & 0xF)
+		return
+	}
+
 	dstReg := insn.Regs & 0xF
 
 	insn.Regs = (value << 4) | dstReg
@@ -91,7 +100,7 @@ func BpfPrintInsns(file string, section string) (string, error) {
 			fmt.Println("Data len:", len(d))
 			bb := bytes.NewBuffer(d)
 			rel64 := elf.Rel64{}
-			binary.Read(bb, binary.LittleEndian, &rel64)
+			binary.Read(bb, elfF.ByteOrder, &rel64)
 			fmt.Println("Rel64 off:", rel64.Off)
 			fmt.Println("Instruction #:", rel64.Off/bpfInsnLen)
 			fmt.Println("Rel64 info:", rel64.Info)
@@ -158,7 +167,7 @@ func BpfPrintInsns(file string, section string) (string, error) {
 
 	insns := make([]bpfInsn, len(d)/bpfInsnLen, len(d)/bpfInsnLen)
 	fmt.Println("DD:", len(insns))
-	err = binary.Read(buf, binary.LittleEndian, insns)
+	err = binary.Read(buf, elfF.ByteOrder, insns)
 	if err != nil {
 		fmt.Println("Could not read instructions:", err)
 	}
@@ -56,19 +56,14 @@ func (e *entry) GetDataPtr() uintptr {
 func TestGetKeysEmpty(t *testing.T) {
 	file := "bpf/simple_map.o"
 	sections := []string{"classifier"}
-	sectionNameToFd := make(map[string]int)
-	mapNameToFd := make(map[string]int)
-
-	err1, err2 := BpfLoadProg(file, sections, sectionNameToFd, mapNameToFd)
-	if err1 != nil {
-		t.Fatal("err1:", err1)
-	}
-	if err2 != nil {
-		t.Fatal("err2:", err2)
-		t.Fail()
+	coll, err := BpfLoadProg(file, sections)
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer coll.Close()
+	mapFd := coll.Maps["map1"].FD()
 
-	keys, err := keyGetKeys(mapNameToFd["map1"])
+	keys, err := keyGetKeys(mapFd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -81,24 +76,19 @@ func TestGetKeysEmpty(t *testing.T) {
 func TestGetKeys(t *testing.T) {
 	file := "bpf/simple_map.o"
 	sections := []string{"classifier"}
-	sectionNameToFd := make(map[string]int)
-	mapNameToFd := make(map[string]int)
-
-	err1, err2 := BpfLoadProg(file, sections, sectionNameToFd, mapNameToFd)
-	if err1 != nil {
-		t.Fatal("err1:", err1)
-	}
-	if err2 != nil {
-		t.Fatal("err2:", err2)
-		t.Fail()
+	coll, err := BpfLoadProg(file, sections)
+	if err != nil {
+		t.Fatal(err)
 	}
+	defer coll.Close()
+	mapFd := coll.Maps["map1"].FD()
 
 	// Add an entry to the map.
 	myKey := key{111, 222}
 	myEntry := entry{}
 	myEntry.valueA = 8888
 	myEntry.valueB = 9999
-	updated, err := BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err := BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -106,7 +96,7 @@ func TestGetKeys(t *testing.T) {
 		t.Fatal("Element should have been updated.")
 	}
 
-	keys, err := keyGetKeys(mapNameToFd["map1"])
+	keys, err := keyGetKeys(mapFd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -125,7 +115,7 @@ func TestGetKeys(t *testing.T) {
 	myKey.b = 444
 	myEntry.valueA = 8888
 	myEntry.valueB = 9999
-	updated, err = BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err = BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -133,7 +123,7 @@ func TestGetKeys(t *testing.T) {
 		t.Fatal("Element should have been updated.")
 	}
 
-	keys, err = keyGetKeys(mapNameToFd["map1"])
+	keys, err = keyGetKeys(mapFd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -159,7 +149,7 @@ func TestGetKeys(t *testing.T) {
 	myKey.b = 666
 	myEntry.valueA = 8888
 	myEntry.valueB = 9999
-	updated, err = BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err = BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -167,7 +157,7 @@ func TestGetKeys(t *testing.T) {
 		t.Fatal("Element should have been updated.")
 	}
 
-	keys, err = keyGetKeys(mapNameToFd["map1"])
+	keys, err = keyGetKeys(mapFd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -194,31 +184,38 @@ func TestGetKeys(t *testing.T) {
 func TestBpfMapOperations(t *testing.T) {
 	file := "bpf/simple_map.o"
 	sections := []string{"classifier"}
-	sectionNameToFd := make(map[string]int)
-	mapNameToFd := make(map[string]int)
-
-	err1, err2 := BpfLoadProg(file, sections, sectionNameToFd, mapNameToFd)
-	if err1 != nil {
-		t.Fatal("err1:", err1)
+	coll, err := BpfLoadProg(file, sections)
+	if err != nil {
+		t.Fatal(err)
 	}
-	if err2 != nil {
-		t.Fatal("err2:", err2)
+	defer coll.Close()
+	mapFd := coll.Maps["map1"].FD()
+
+	if len(coll.Programs) != 1 {
 		t.Fail()
 	}
 
-	if len(sectionNameToFd) != 1 {
+	if len(coll.Maps) != 1 {
 		t.Fail()
 	}
 
-	if len(mapNameToFd) != 1 {
-		t.Fail()
+	m := coll.Maps["map1"]
+	if m.Type != MapTypeHash || m.KeySize != 8 || m.ValueSize != 16 || m.MaxEntries != 256 {
+		t.Fatal("Bad map definition:", m)
+	}
+
+	if coll.Programs["classifier"].Type != ProgTypeSchedCls {
+		t.Fatal("Bad program type:", coll.Programs["classifier"].Type)
+	}
+	if coll.Programs["classifier"].Name != "cls_main" {
+		t.Fatal("Bad program name:", coll.Programs["classifier"].Name)
 	}
 
 	myKey := key{111, 222}
 	myEntry := entry{}
 
 	// First lookup should fail (empty map).
-	found, err := BpfMapLookupElem(mapNameToFd["map1"], &myKey, &myEntry)
+	found, err := BpfMapLookupElem(mapFd, &myKey, &myEntry)
 	if err != nil {
 		t.Log("err:", err)
 		t.Fail()
@@ -230,7 +227,7 @@ func TestBpfMapOperations(t *testing.T) {
 	// Add an entry to the map.
 	myEntry.valueA = 8888
 	myEntry.valueB = 9999
-	updated, err := BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err := BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -239,7 +236,7 @@ func TestBpfMapOperations(t *testing.T) {
 	}
 
 	// Now search the map and verify we get the correct data back.
-	found, err = BpfMapLookupElem(mapNameToFd["map1"], &myKey, &myEntry)
+	found, err = BpfMapLookupElem(mapFd, &myKey, &myEntry)
 	if err != nil {
 		t.Log("err:", err)
 		t.Fail()
@@ -256,7 +253,7 @@ func TestBpfMapOperations(t *testing.T) {
 	}
 
 	// Now delete the entry.
-	deleted, err := BpfMapDeleteElem(mapNameToFd["map1"], &myKey)
+	deleted, err := BpfMapDeleteElem(mapFd, &myKey)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -265,7 +262,7 @@ func TestBpfMapOperations(t *testing.T) {
 	}
 
 	// Verify that the key/entry is gone from the map.
-	found, err = BpfMapLookupElem(mapNameToFd["map1"], &myKey, &myEntry)
+	found, err = BpfMapLookupElem(mapFd, &myKey, &myEntry)
 	if err != nil {
 		t.Log("err:", err)
 		t.Fail()
@@ -275,7 +272,7 @@ func TestBpfMapOperations(t *testing.T) {
 	}
 
 	// Add multiple entries to the map and verify that we can iterate over the map.
-	updated, err = BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err = BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -287,7 +284,7 @@ func TestBpfMapOperations(t *testing.T) {
 	myKey.b = 444
 	myEntry.valueA = 6666
 	myEntry.valueB = 7777
-	updated, err = BpfMapUpdateElem(mapNameToFd["map1"], &myKey, &myEntry, 0)
+	updated, err = BpfMapUpdateElem(mapFd, &myKey, &myEntry, 0)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -295,7 +292,7 @@ func TestBpfMapOperations(t *testing.T) {
 		t.Fatal("Element should have been updated.")
 	}
 
-	keys, err := keyGetKeys(mapNameToFd["map1"])
+	keys, err := keyGetKeys(mapFd)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -305,3 +302,36 @@ func TestBpfMapOperations(t *testing.T) {
 		t.Fail()
 	}
 }
+
+func TestProgTypeFromSection(t *testing.T) {
+	tests := []struct {
+		section  string
+		progType ProgType
+	}{
+		{"classifier", ProgTypeSchedCls},
+		{"action", ProgTypeSchedAct},
+		{"xdp", ProgTypeXdp},
+		{"xdp/drop", ProgTypeXdp},
+		{"kprobe/sys_open", ProgTypeKprobe},
+		{"kretprobe/sys_open", ProgTypeKprobe},
+		{"tracepoint/sched/sched_switch", ProgTypeTracepoint},
+		{"socket", ProgTypeSocketFilter},
+		{"socket1", ProgTypeSocketFilter},
+		{"cgroup/skb", ProgTypeCgroupSkb},
+		{"cgroup_skb/egress", ProgTypeCgroupSkb},
+		{"cgroup/sock", ProgTypeCgroupSock},
+		{"cgroup/sock_create", ProgTypeCgroupSock},
+		{"cgroup/post_bind4", ProgTypeCgroupSock},
+		{"cgroup/sockopt", ProgTypeSchedCls},
+		{"cgroup/sock_release", ProgTypeSchedCls},
+		{"sockops", ProgTypeSockOps},
+		{"cgroup/dev", ProgTypeCgroupDevice},
+		{"prog", ProgTypeSchedCls},
+	}
+
+	for _, test := range tests {
+		if progType := progTypeFromSection(test.section); progType != test.progType {
+			t.Errorf("Section %s: got program type %d, want %d", test.section, progType, test.progType)
+		}
+	}
+}
@@ -0,0 +1,188 @@
+package bpf
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoBpffs is returned by BpffsMountpoint when no bpf filesystem is mounted.
+var ErrNoBpffs = errors.New("no bpf filesystem is mounted")
+
+const mountinfoFile = "/proc/self/mountinfo"
+
+// IsBpffs reports whether path is on a bpf filesystem, the only place maps and programs can be pinned.
+func IsBpffs(path string) (bool, error) {
+	var st unix.Statfs_t
+	if err := unix.Statfs(path, &st); err != nil {
+		return false, &os.PathError{Op: "statfs", Path: path, Err: err}
+	}
+
+	return uint32(st.Type) == uint32(unix.BPF_FS_MAGIC), nil
+}
+
+// checkBpffs returns an error unless dir is on a bpf filesystem.
+func checkBpffs(dir string) error {
+	ok, err := IsBpffs(dir)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("%s isn't on a bpf filesystem; mount one with MountBpffs", dir)
+	}
+
+	return nil
+}
+
+// nearestExistingDir returns dir, or its closest ancestor if dir doesn't exist yet.
+func nearestExistingDir(dir string) string {
+	for {
+		if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+			return dir
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// BpffsMountpoint returns where a bpf filesystem is mounted in the current mount namespace, preferring
+// /sys/fs/bpf if several are. It returns ErrNoBpffs if there is none.
+func BpffsMountpoint() (string, error) {
+	f, err := os.Open(mountinfoFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	mounts, err := parseMountinfo(f)
+	if err != nil {
+		return "", err
+	}
+
+	return findBpffs(mounts)
+}
+
+// MountBpffs mounts a bpf filesystem on dir, /sys/fs/bpf if dir is empty, creating dir if needed. It does nothing
+// if dir is already on a bpf filesystem.
+func MountBpffs(dir string) error {
+	if dir == "" {
+		dir = bpfPinPathDefault
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+
+	ok, err := IsBpffs(dir)
+	if err != nil || ok {
+		return err
+	}
+
+	if err := unix.Mount("bpf", dir, "bpf", 0, "mode=0700"); err != nil {
+		return &os.PathError{Op: "mount bpffs", Path: dir, Err: err}
+	}
+
+	return nil
+}
+
+// bpfDefaultPinPath is the bpffs mount point used when LoadOptions.PinPath is empty: wherever bpffs is mounted,
+// like tc, or /sys/fs/bpf if it isn't mounted anywhere.
+func bpfDefaultPinPath() string {
+	mountpoint, err := BpffsMountpoint()
+	if err != nil {
+		return bpfPinPathDefault
+	}
+
+	return mountpoint
+}
+
+// mountInfo is the part of a /proc/self/mountinfo line this package needs.
+type mountInfo struct {
+	mountpoint string
+	fsType     string
+}
+
+// parseMountinfo parses the format of /proc/<pid>/mountinfo, described in proc(5).
+func parseMountinfo(r io.Reader) ([]mountInfo, error) {
+	var mounts []mountInfo
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+
+		// The optional fields after the mount point are terminated by a lone "-".
+		pre, post, found := strings.Cut(line, " - ")
+		if !found {
+			return nil, fmt.Errorf("Bad mountinfo line %q", line)
+		}
+		preFields := strings.Fields(pre)
+		postFields := strings.Fields(post)
+		if len(preFields) < 5 || len(postFields) < 1 {
+			return nil, fmt.Errorf("Bad mountinfo line %q", line)
+		}
+
+		mounts = append(mounts, mountInfo{
+			mountpoint: unescapeMountinfo(preFields[4]),
+			fsType:     postFields[0],
+		})
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return mounts, nil
+}
+
+// unescapeMountinfo undoes the octal escapes (\040 for a space and so on) the kernel uses in mountinfo paths.
+func unescapeMountinfo(s string) string {
+	if !strings.Contains(s, `\`) {
+		return s
+	}
+
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] == '\\' && i+4 <= len(s) {
+			if c, err := strconv.ParseUint(s[i+1:i+4], 8, 8); err == nil {
+				b.WriteByte(byte(c))
+				i += 3
+				continue
+			}
+		}
+		b.WriteByte(s[i])
+	}
+
+	return b.String()
+}
+
+// findBpffs picks the bpffs mount point out of mounts, preferring /sys/fs/bpf.
+func findBpffs(mounts []mountInfo) (string, error) {
+	found := ""
+	for _, m := range mounts {
+		if m.fsType != "bpf" {
+			continue
+		}
+		if m.mountpoint == bpfPinPathDefault {
+			return m.mountpoint, nil
+		}
+		if found == "" {
+			found = m.mountpoint
+		}
+	}
+
+	if found == "" {
+		return "", ErrNoBpffs
+	}
+
+	return found, nil
+}
@@ -0,0 +1,114 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const testMountinfo = `22 28 0:20 / /sys rw,nosuid,nodev,noexec,relatime shared:7 - sysfs sysfs rw
+28 1 8:1 / / rw,relatime shared:1 - ext4 /dev/sda1 rw
+40 22 0:34 / /sys/fs/bpf rw,nosuid,nodev,noexec,relatime shared:15 - bpf bpf rw,mode=700
+90 28 0:50 / /run/my\040bpf rw,relatime - bpf none rw
+`
+
+func TestParseMountinfo(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []mountInfo{
+		{"/sys", "sysfs"},
+		{"/", "ext4"},
+		{"/sys/fs/bpf", "bpf"},
+		{"/run/my bpf", "bpf"},
+	}
+	if len(mounts) != len(want) {
+		t.Fatal("Wrong number of mounts:", mounts)
+	}
+	for i := range want {
+		if mounts[i] != want[i] {
+			t.Errorf("Mount %d: got %v, want %v", i, mounts[i], want[i])
+		}
+	}
+
+	if _, err := parseMountinfo(strings.NewReader("garbage\n")); err == nil {
+		t.Fatal("Bad mountinfo should be rejected.")
+	}
+}
+
+func TestFindBpffs(t *testing.T) {
+	mounts, err := parseMountinfo(strings.NewReader(testMountinfo))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// /sys/fs/bpf wins even though another bpffs is mounted.
+	if mountpoint, err := findBpffs(mounts); err != nil || mountpoint != "/sys/fs/bpf" {
+		t.Fatal("Bad mountpoint:", mountpoint, err)
+	}
+	if mountpoint, err := findBpffs(mounts[3:]); err != nil || mountpoint != "/run/my bpf" {
+		t.Fatal("Bad mountpoint:", mountpoint, err)
+	}
+	if _, err := findBpffs(mounts[:2]); !errors.Is(err, ErrNoBpffs) {
+		t.Fatal("Missing bpffs should be reported:", err)
+	}
+}
+
+func TestMountBpffs(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "bpf")
+
+	if err := MountBpffs(dir); err != nil {
+		t.Skip("Can't mount bpffs:", err)
+	}
+	t.Cleanup(func() {
+		unmountTestBpffs(dir)
+	})
+
+	ok, err := IsBpffs(dir)
+	if err != nil || !ok {
+		t.Fatal("Directory should be on bpffs:", ok, err)
+	}
+	ok, err = IsBpffs(filepath.Dir(dir))
+	if err != nil || ok {
+		t.Fatal("Parent directory shouldn't be on bpffs:", ok, err)
+	}
+
+	// Mounting again does nothing.
+	if err := MountBpffs(dir); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := BpffsMountpoint(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestPinWithoutBpffs(t *testing.T) {
+	// Without bpffs mounted, /sys/fs/bpf is a plain sysfs directory, where mkdir fails with EPERM. Pinning below a
+	// sysfs directory must report the missing bpffs instead, and create nothing.
+	var st unix.Statfs_t
+	if err := unix.Statfs("/sys/fs", &st); err != nil || uint32(st.Type) != uint32(unix.SYSFS_MAGIC) {
+		t.Skip("/sys/fs isn't on sysfs.")
+	}
+
+	m, err := NewMap(MapTypeHash, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	dir := "/sys/fs/puregobpf_test"
+	err = m.Pin(filepath.Join(dir, "tc", "globals", "map"))
+	if err == nil || !strings.Contains(err.Error(), "isn't on a bpf filesystem") {
+		t.Fatal("Pinning below sysfs should report the missing bpffs:", err)
+	}
+	if _, err := os.Stat(dir); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Pinning below sysfs shouldn't create directories:", err)
+	}
+}
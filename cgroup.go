@@ -0,0 +1,182 @@
+package bpf
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"strings"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// AttachType is where a program is attached, passed to the kernel as attach_type and, for some program types,
+// as expected_attach_type when the program is loaded.
+type AttachType uint32
+
+// Attach types from enum bpf_attach_type for programs attached to cgroups.
+const (
+	AttachCgroupInetIngress    AttachType = 0  // cgroup-skb: packets received by sockets in the cgroup.
+	AttachCgroupInetEgress     AttachType = 1  // cgroup-skb: packets sent by sockets in the cgroup.
+	AttachCgroupInetSockCreate AttachType = 2  // cgroup-sock: inet sockets created in the cgroup.
+	AttachCgroupSockOps        AttachType = 3  // sock_ops: TCP events of sockets in the cgroup.
+	AttachCgroupDevice         AttachType = 6  // cgroup-device: device accesses by processes in the cgroup.
+	AttachCgroupInet4PostBind  AttachType = 12 // cgroup-sock: IPv4 sockets bound in the cgroup.
+	AttachCgroupInet6PostBind  AttachType = 13 // cgroup-sock: IPv6 sockets bound in the cgroup.
+)
+
+// Flags for AttachCgroup. Without either, the cgroup takes a single program that replaces the one attached
+// before, and descendant cgroups can't attach their own.
+const (
+	AttachFlagAllowOverride = unix.BPF_F_ALLOW_OVERRIDE // Descendants can attach a program that runs instead.
+	AttachFlagAllowMulti    = unix.BPF_F_ALLOW_MULTI    // Programs here and in descendants all run, in order.
+)
+
+// QueryFlagEffective makes QueryCgroup return the programs that run for the cgroup, including the ones inherited
+// from its ancestors, rather than only the ones attached to it.
+const QueryFlagEffective = unix.BPF_F_QUERY_EFFECTIVE
+
+// attachTypeFromSection works out the expected attach type from an ELF section name, using the libbpf
+// conventions. Sections that don't imply one get 0, which all the program types of progTypeFromSection accept.
+func attachTypeFromSection(section string) AttachType {
+	switch {
+	case section == "cgroup_skb/egress":
+		return AttachCgroupInetEgress
+	case strings.HasPrefix(section, "cgroup/post_bind4"):
+		return AttachCgroupInet4PostBind
+	case strings.HasPrefix(section, "cgroup/post_bind6"):
+		return AttachCgroupInet6PostBind
+	case section == "cgroup/sock" || section == "cgroup/sock_create":
+		return AttachCgroupInetSockCreate
+	}
+
+	return AttachCgroupInetIngress
+}
+
+// AttachCgroup attaches prog to the cgroup v2 directory open as cgroupFd, such as from os.Open on
+// /sys/fs/cgroup/<name>. The program keeps running after prog is closed, until DetachCgroup is called or the
+// cgroup is removed.
+func AttachCgroup(cgroupFd int, prog *Program, attachType AttachType, flags uint32) error {
+	attrs := bpfProgAttachAttr{}
+	attrs.targetFd = uint32(cgroupFd)
+	attrs.attachBpfFd = uint32(prog.fd)
+	attrs.attachType = uint32(attachType)
+	attrs.attachFlags = flags
+
+	_, serr := bpfSyscall(bpfCmdProgAttach, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		return withName(newError(bpfCmdProgAttach, serr, prog.fd), prog.Name)
+	}
+
+	return nil
+}
+
+// DetachCgroup detaches prog from the cgroup. prog can be nil if the program was attached without
+// AttachFlagAllowMulti, since there is then only one.
+func DetachCgroup(cgroupFd int, prog *Program, attachType AttachType) error {
+	attrs := bpfProgAttachAttr{}
+	attrs.targetFd = uint32(cgroupFd)
+	attrs.attachType = uint32(attachType)
+
+	progFd := -1
+	name := ""
+	if prog != nil {
+		progFd = prog.fd
+		name = prog.Name
+		attrs.attachBpfFd = uint32(prog.fd)
+	}
+
+	_, serr := bpfSyscall(bpfCmdProgDetach, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		return withName(newError(bpfCmdProgDetach, serr, progFd), name)
+	}
+
+	return nil
+}
+
+// QueryCgroup returns the ids of the programs attached to the cgroup at attachType, in the order they run, and
+// the flags they were attached with. queryFlags can be QueryFlagEffective.
+func QueryCgroup(cgroupFd int, attachType AttachType, queryFlags uint32) ([]uint32, uint32, error) {
+	var ids []uint32
+
+	for {
+		attrs := bpfProgQueryAttr{}
+		attrs.targetFd = uint32(cgroupFd)
+		attrs.attachType = uint32(attachType)
+		attrs.queryFlags = queryFlags
+		attrs.progCnt = uint32(len(ids))
+		if len(ids) > 0 {
+			attrs.progIDs = bpfPtr(unsafe.Pointer(&ids[0]))
+		}
+
+		_, serr := bpfSyscall(bpfCmdProgQuery, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+		runtime.KeepAlive(ids)
+
+		// ENOSPC means more programs were attached since the count was read, so try again with more room.
+		if serr == unix.ENOSPC || serr == 0 && int(attrs.progCnt) > len(ids) {
+			ids = make([]uint32, attrs.progCnt+1)
+			continue
+		}
+		if serr != 0 {
+			return nil, 0, newError(bpfCmdProgQuery, serr, cgroupFd)
+		}
+
+		return ids[:attrs.progCnt], attrs.attachFlags, nil
+	}
+}
+
+// CgroupArray wraps a cgroup array map, whose slots hold cgroups that programs check packets and tasks against
+// with bpf_skb_under_cgroup and bpf_current_task_under_cgroup.
+type CgroupArray struct {
+	m *Map
+}
+
+// NewCgroupArray wraps m, which must be a cgroup array map.
+func NewCgroupArray(m *Map) (*CgroupArray, error) {
+	if m.Type != MapTypeCgroupArray {
+		return nil, fmt.Errorf("Map %s isn't a cgroup array", m.Name)
+	}
+	if m.KeySize != 4 || m.ValueSize != 4 {
+		return nil, fmt.Errorf("Cgroup array %s has %d byte keys and %d byte values, want 4 and 4", m.Name,
+			m.KeySize, m.ValueSize)
+	}
+
+	return &CgroupArray{m: m}, nil
+}
+
+// Map returns the underlying map.
+func (a *CgroupArray) Map() *Map {
+	return a.m
+}
+
+// Set puts the cgroup v2 directory open as cgroupFd into slot index. The array holds its own reference, so the fd
+// can be closed afterwards.
+func (a *CgroupArray) Set(index uint32, cgroupFd int) error {
+	err := fdArraySet(a.m.fd, index, cgroupFd)
+	runtime.KeepAlive(a.m)
+
+	return withName(err, a.m.Name)
+}
+
+// SetPath puts the cgroup v2 directory at path into slot index.
+func (a *CgroupArray) SetPath(index uint32, path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	return a.Set(index, int(f.Fd()))
+}
+
+// Delete empties slot index. It returns false if the slot was already empty.
+func (a *CgroupArray) Delete(index uint32) (bool, error) {
+	keyBuf := make([]byte, 4)
+	nativeEndian.PutUint32(keyBuf, index)
+
+	deleted, err := bpfMapDeleteElem(a.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(a.m)
+
+	return deleted, withName(err, a.m.Name)
+}
@@ -0,0 +1,231 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// openTestCgroup creates a cgroup v2 directory for the test and returns its path and an fd for it. It skips the
+// test if there is no cgroup v2 hierarchy.
+func openTestCgroup(t *testing.T) (string, int) {
+	t.Helper()
+
+	f, err := os.Open(mountinfoFile)
+	if err != nil {
+		t.Skip(err)
+	}
+	mounts, err := parseMountinfo(f)
+	f.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := ""
+	for _, m := range mounts {
+		if m.fsType == "cgroup2" {
+			root = m.mountpoint
+			break
+		}
+	}
+	if root == "" {
+		t.Skip("No cgroup v2 hierarchy is mounted.")
+	}
+
+	dir, err := os.MkdirTemp(root, "puregobpf")
+	if err != nil {
+		t.Skip("Can't create a cgroup:", err)
+	}
+
+	fd, err := unix.Open(dir, unix.O_RDONLY|unix.O_DIRECTORY|unix.O_CLOEXEC, 0)
+	if err != nil {
+		os.Remove(dir)
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		unix.Close(fd)
+		os.Remove(dir)
+	})
+
+	return dir, fd
+}
+
+func TestAttachTypeFromSection(t *testing.T) {
+	tests := []struct {
+		section    string
+		attachType AttachType
+	}{
+		{"cgroup/skb", AttachCgroupInetIngress},
+		{"cgroup_skb/ingress", AttachCgroupInetIngress},
+		{"cgroup_skb/egress", AttachCgroupInetEgress},
+		{"cgroup/sock", AttachCgroupInetSockCreate},
+		{"cgroup/sock_create", AttachCgroupInetSockCreate},
+		{"cgroup/sockopt", 0},
+		{"cgroup/sock_release", 0},
+		{"cgroup/post_bind4", AttachCgroupInet4PostBind},
+		{"cgroup/post_bind6", AttachCgroupInet6PostBind},
+		{"classifier", 0},
+	}
+
+	for _, test := range tests {
+		if attachType := attachTypeFromSection(test.section); attachType != test.attachType {
+			t.Errorf("Section %s: got attach type %d, want %d", test.section, attachType, test.attachType)
+		}
+	}
+}
+
+func TestCgroupAttach(t *testing.T) {
+	_, cgroupFd := openTestCgroup(t)
+
+	first := loadTestProg(t, "allow1", ProgTypeCgroupSkb, 1)
+	defer first.Close()
+	second := loadTestProg(t, "allow2", ProgTypeCgroupSkb, 1)
+	defer second.Close()
+
+	for _, prog := range []*Program{first, second} {
+		if err := AttachCgroup(cgroupFd, prog, AttachCgroupInetEgress, AttachFlagAllowMulti); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ids, flags, err := QueryCgroup(cgroupFd, AttachCgroupInetEgress, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ids) != 2 || ids[0] != testProgID(t, first) || ids[1] != testProgID(t, second) ||
+		flags != AttachFlagAllowMulti {
+		t.Fatal("Bad query result:", ids, flags)
+	}
+	if ids, _, err := QueryCgroup(cgroupFd, AttachCgroupInetIngress, 0); err != nil || len(ids) != 0 {
+		t.Fatal("Nothing should be attached on ingress:", ids, err)
+	}
+
+	if err := DetachCgroup(cgroupFd, first, AttachCgroupInetEgress); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _, err := QueryCgroup(cgroupFd, AttachCgroupInetEgress, 0); err != nil || len(ids) != 1 ||
+		ids[0] != testProgID(t, second) {
+		t.Fatal("Only the second program should be left:", ids, err)
+	}
+	if err := DetachCgroup(cgroupFd, second, AttachCgroupInetEgress); err != nil {
+		t.Fatal(err)
+	}
+
+	// Without AttachFlagAllowMulti, attaching again replaces the program.
+	if err := AttachCgroup(cgroupFd, first, AttachCgroupInetIngress, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := AttachCgroup(cgroupFd, second, AttachCgroupInetIngress, 0); err != nil {
+		t.Fatal(err)
+	}
+	if ids, _, err := QueryCgroup(cgroupFd, AttachCgroupInetIngress, 0); err != nil || len(ids) != 1 ||
+		ids[0] != testProgID(t, second) {
+		t.Fatal("The second program should have replaced the first:", ids, err)
+	}
+	if err := DetachCgroup(cgroupFd, nil, AttachCgroupInetIngress); err != nil {
+		t.Fatal(err)
+	}
+	if err := DetachCgroup(cgroupFd, nil, AttachCgroupInetIngress); !errors.Is(err, unix.ENOENT) {
+		t.Fatal("Detaching twice should fail with ENOENT:", err)
+	}
+}
+
+func TestCgroupProgTypes(t *testing.T) {
+	_, cgroupFd := openTestCgroup(t)
+
+	tests := []struct {
+		progType   ProgType
+		attachType AttachType
+	}{
+		{ProgTypeCgroupSock, AttachCgroupInetSockCreate},
+		{ProgTypeSockOps, AttachCgroupSockOps},
+		{ProgTypeCgroupDevice, AttachCgroupDevice},
+	}
+
+	for _, test := range tests {
+		prog := loadTestProg(t, "allow", test.progType, 1)
+		defer prog.Close()
+
+		if err := AttachCgroup(cgroupFd, prog, test.attachType, AttachFlagAllowOverride); err != nil {
+			t.Fatal(err)
+		}
+		ids, flags, err := QueryCgroup(cgroupFd, test.attachType, QueryFlagEffective)
+		if err != nil || len(ids) != 1 || ids[0] != testProgID(t, prog) {
+			t.Fatal("Bad query result:", test.progType, ids, err)
+		}
+		if flags != 0 {
+			t.Fatal("Effective queries don't report flags:", flags)
+		}
+		if err := DetachCgroup(cgroupFd, prog, test.attachType); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Post bind programs need their attach type when they are loaded.
+	insns := []bpfInsn{{Code: insnMovR0Imm0.Code, Imm: 1}, insnExit}
+	spec := bpfProgSpec{name: "post_bind4", progType: ProgTypeCgroupSock,
+		expectedAttachType: uint32(AttachCgroupInet4PostBind), insns: insns, license: testLicense}
+	fd, verr := bpfProgLoad(&spec, LogLevelInstruction, 0)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+	prog := &Program{Name: spec.name, Type: spec.progType}
+	prog.setFD(fd)
+	defer prog.Close()
+
+	if err := AttachCgroup(cgroupFd, prog, AttachCgroupInet4PostBind, 0); err != nil {
+		t.Fatal(err)
+	}
+	if err := AttachCgroup(cgroupFd, prog, AttachCgroupInet6PostBind, 0); err == nil {
+		t.Fatal("Attaching with another attach type than the program was loaded with should fail.")
+	}
+	if err := DetachCgroup(cgroupFd, nil, AttachCgroupInet4PostBind); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestCgroupArray(t *testing.T) {
+	dir, cgroupFd := openTestCgroup(t)
+
+	m, err := NewMap(MapTypeCgroupArray, 4, 4, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	array, err := NewCgroupArray(m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := array.Set(0, cgroupFd); err != nil {
+		t.Fatal(err)
+	}
+	if err := array.SetPath(1, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := array.Set(2, cgroupFd); err == nil {
+		t.Fatal("Setting a slot past the end should fail.")
+	}
+
+	for _, index := range []uint32{0, 1} {
+		if deleted, err := array.Delete(index); err != nil || !deleted {
+			t.Fatal("Slot should have held the cgroup:", index, deleted, err)
+		}
+	}
+	if deleted, err := array.Delete(0); err != nil || deleted {
+		t.Fatal("Slot should be empty:", deleted, err)
+	}
+
+	hash, err := NewMap(MapTypeHash, 4, 4, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer hash.Close()
+
+	if _, err := NewCgroupArray(hash); err == nil {
+		t.Fatal("A hash map shouldn't be accepted as a cgroup array.")
+	}
+}
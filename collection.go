@@ -0,0 +1,33 @@
+package bpf
+
+// Collection holds the programs and maps loaded from an ELF file.
+type Collection struct {
+	Programs map[string]*Program // Keyed by section name.
+	Maps     map[string]*Map     // Keyed by map name.
+}
+
+func newCollection() *Collection {
+	return &Collection{
+		Programs: make(map[string]*Program),
+		Maps:     make(map[string]*Map),
+	}
+}
+
+// Close releases the file descriptors of every program and map in the collection. The kernel frees the objects
+// once nothing else (an attached filter, a pin, another fd) holds a reference to them.
+func (c *Collection) Close() error {
+	var firstErr error
+
+	for _, p := range c.Programs {
+		if err := p.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	for _, m := range c.Maps {
+		if err := m.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+
+	return firstErr
+}
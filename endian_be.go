@@ -0,0 +1,8 @@
+//go:build mips || mips64 || ppc64 || s390x
+
+package bpf
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of the host. eBPF objects must be compiled for it (bpfeb).
+var nativeEndian binary.ByteOrder = binary.BigEndian
@@ -0,0 +1,8 @@
+//go:build 386 || amd64 || arm || arm64 || loong64 || mips64le || mipsle || ppc64le || riscv64
+
+package bpf
+
+import "encoding/binary"
+
+// nativeEndian is the byte order of the host. eBPF objects must be compiled for it (bpfel).
+var nativeEndian binary.ByteOrder = binary.LittleEndian
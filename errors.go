@@ -0,0 +1,118 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+var (
+	// ErrKeyExist is matched by errors from map updates that failed because the key was already present.
+	ErrKeyExist = errors.New("key already exists")
+	// ErrKeyNotExist is matched by errors from map operations that failed because the key wasn't present.
+	ErrKeyNotExist = errors.New("key does not exist")
+)
+
+// Cmd is a bpf(2) command.
+type Cmd uint32
+
+var cmdNames = map[Cmd]string{
+	bpfCmdMapCreate:     "BPF_MAP_CREATE",
+	bpfCmdMapLookupElem: "BPF_MAP_LOOKUP_ELEM",
+	bpfCmdMapUpdateElem: "BPF_MAP_UPDATE_ELEM",
+	bpfCmdMapDeleteElem: "BPF_MAP_DELETE_ELEM",
+	bpfCmdMapGetNextKey: "BPF_MAP_GET_NEXT_KEY",
+	bpfCmdProgLoad:      "BPF_PROG_LOAD",
+	bpfCmdObjPin:        "BPF_OBJ_PIN",
+	bpfCmdObjGet:        "BPF_OBJ_GET",
+
+	bpfCmdProgAttach:              "BPF_PROG_ATTACH",
+	bpfCmdProgDetach:              "BPF_PROG_DETACH",
+	bpfCmdProgTestRun:             "BPF_PROG_TEST_RUN",
+	bpfCmdProgGetNextID:           "BPF_PROG_GET_NEXT_ID",
+	bpfCmdMapGetNextID:            "BPF_MAP_GET_NEXT_ID",
+	bpfCmdProgGetFdByID:           "BPF_PROG_GET_FD_BY_ID",
+	bpfCmdMapGetFdByID:            "BPF_MAP_GET_FD_BY_ID",
+	bpfCmdObjGetInfoByFd:          "BPF_OBJ_GET_INFO_BY_FD",
+	bpfCmdProgQuery:               "BPF_PROG_QUERY",
+	bpfCmdRawTracepointOpen:       "BPF_RAW_TRACEPOINT_OPEN",
+	bpfCmdBtfLoad:                 "BPF_BTF_LOAD",
+	bpfCmdBtfGetFdByID:            "BPF_BTF_GET_FD_BY_ID",
+	bpfCmdTaskFdQuery:             "BPF_TASK_FD_QUERY",
+	bpfCmdMapLookupAndDeleteElem:  "BPF_MAP_LOOKUP_AND_DELETE_ELEM",
+	bpfCmdMapFreeze:               "BPF_MAP_FREEZE",
+	bpfCmdBtfGetNextID:            "BPF_BTF_GET_NEXT_ID",
+	bpfCmdMapLookupBatch:          "BPF_MAP_LOOKUP_BATCH",
+	bpfCmdMapLookupAndDeleteBatch: "BPF_MAP_LOOKUP_AND_DELETE_BATCH",
+	bpfCmdMapUpdateBatch:          "BPF_MAP_UPDATE_BATCH",
+	bpfCmdMapDeleteBatch:          "BPF_MAP_DELETE_BATCH",
+}
+
+func (c Cmd) String() string {
+	if name, ok := cmdNames[c]; ok {
+		return name
+	}
+
+	return fmt.Sprintf("BPF_CMD(%d)", uint32(c))
+}
+
+// Error is returned when a bpf(2) call fails. It unwraps to the errno so callers can use errors.Is, for example
+// errors.Is(err, unix.EPERM) for missing privileges or errors.Is(err, unix.E2BIG) for a full map.
+type Error struct {
+	Cmd   Cmd        // The bpf(2) command that failed.
+	Errno unix.Errno // The errno returned by the kernel.
+	FD    int        // The fd of the map or program the command operated on, -1 if there wasn't one.
+	Name  string     // The name of the map or program, if known.
+}
+
+func (e *Error) Error() string {
+	msg := e.Cmd.String()
+	if e.Name != "" {
+		msg += " " + e.Name
+	}
+	if e.FD >= 0 {
+		msg += fmt.Sprintf(" (fd %d)", e.FD)
+	}
+
+	return msg + ": " + e.Errno.Error()
+}
+
+// Unwrap returns the errno.
+func (e *Error) Unwrap() error {
+	return e.Errno
+}
+
+// Is lets errors.Is match map element errors against ErrKeyExist and ErrKeyNotExist.
+func (e *Error) Is(target error) bool {
+	switch e.Cmd {
+	case bpfCmdMapLookupElem, bpfCmdMapUpdateElem, bpfCmdMapDeleteElem, bpfCmdMapGetNextKey,
+		bpfCmdMapLookupAndDeleteElem, bpfCmdMapUpdateBatch, bpfCmdMapDeleteBatch:
+	default:
+		return false
+	}
+
+	switch target {
+	case ErrKeyExist:
+		return e.Errno == unix.EEXIST
+	case ErrKeyNotExist:
+		return e.Errno == unix.ENOENT
+	}
+
+	return false
+}
+
+// newError builds an *Error for a failed command on fd.
+func newError(cmd Cmd, errno unix.Errno, fd int) *Error {
+	return &Error{Cmd: cmd, Errno: errno, FD: fd}
+}
+
+// withName records name on err if it is an *Error that doesn't have a name yet.
+func withName(err error, name string) error {
+	var bpfErr *Error
+	if name != "" && errors.As(err, &bpfErr) && bpfErr.Name == "" {
+		bpfErr.Name = name
+	}
+
+	return err
+}
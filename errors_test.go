@@ -0,0 +1,54 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestErrorIs(t *testing.T) {
+	err := fmt.Errorf("loading: %w", &Error{Cmd: bpfCmdMapCreate, Errno: unix.EPERM, FD: -1, Name: "map1"})
+
+	if !errors.Is(err, unix.EPERM) {
+		t.Fatal("Error should match EPERM:", err)
+	}
+	if errors.Is(err, unix.ENOMEM) {
+		t.Fatal("Error shouldn't match ENOMEM:", err)
+	}
+
+	var bpfErr *Error
+	if !errors.As(err, &bpfErr) || bpfErr.Cmd != bpfCmdMapCreate {
+		t.Fatal("Error should unwrap to *Error:", err)
+	}
+
+	want := "loading: BPF_MAP_CREATE map1: operation not permitted"
+	if err.Error() != want {
+		t.Fatalf("Got %q, want %q", err.Error(), want)
+	}
+}
+
+func TestErrorMapFull(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+	m.Name = "tiny"
+
+	myEntry := entry{}
+	if _, err := m.Update(&key{1, 1}, &myEntry, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = m.Update(&key{2, 2}, &myEntry, 0)
+	if !errors.Is(err, unix.E2BIG) {
+		t.Fatal("Expected E2BIG from a full map, got:", err)
+	}
+
+	var bpfErr *Error
+	if !errors.As(err, &bpfErr) || bpfErr.Cmd != bpfCmdMapUpdateElem || bpfErr.FD != m.FD() || bpfErr.Name != "tiny" {
+		t.Fatal("Bad error details:", err)
+	}
+}
@@ -0,0 +1,157 @@
+package bpf
+
+import (
+	"encoding/binary"
+	"testing"
+)
+
+var testLicense = []byte("GPL\x00")
+
+// Instructions used to build test programs by hand.
+var (
+	insnMovR0Imm0 = bpfInsn{Code: 0xb7} // r0 = 0
+	insnExit      = bpfInsn{Code: 0x95} // exit
+)
+
+// loadTestProg loads a program of progType that returns ret.
+func loadTestProg(t *testing.T, name string, progType ProgType, ret int32) *Program {
+	t.Helper()
+
+	insns := []bpfInsn{{Code: insnMovR0Imm0.Code, Imm: ret}, insnExit} // r0 = ret; exit
+	spec := bpfProgSpec{name: name, progType: progType, insns: insns, license: testLicense}
+
+	fd, verr := bpfProgLoad(&spec, LogLevelInstruction, 0)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+
+	prog := &Program{Name: name, Type: progType}
+	prog.setFD(fd)
+
+	return prog
+}
+
+// testInsn builds an instruction with dst and src placed in Regs the same way SetSrcReg does.
+func testInsn(code uint8, dst uint8, src uint8, offset int16, imm int32) bpfInsn {
+	insn := bpfInsn{Code: code, Regs: dst, Offset: offset, Imm: imm}
+	if nativeEndian == binary.BigEndian {
+		insn.Regs = dst << 4
+	}
+	insn.SetSrcReg(src)
+
+	return insn
+}
+
+// loadTestCounterProg loads a program of progType that adds 1 to the u64 at key 0 of the returned array map every
+// time it runs. The map is closed when the test ends.
+func loadTestCounterProg(t *testing.T, name string, progType ProgType) (*Program, *TypedMap[uint32, uint64]) {
+	t.Helper()
+
+	m, err := NewMap(MapTypeArray, 4, 8, 1, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Close() })
+
+	counter, err := NewTypedMap[uint32, uint64](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	insns := []bpfInsn{
+		testInsn(0x18, 1, 1, 0, int32(m.FD())), // r1 = map (src 1 is BPF_PSEUDO_MAP_FD)
+		{},
+		testInsn(0x62, 10, 0, -4, 0), // *(u32 *)(r10 - 4) = 0
+		testInsn(0xbf, 2, 10, 0, 0),  // r2 = r10
+		testInsn(0x07, 2, 0, 0, -4),  // r2 += -4
+		testInsn(0x85, 0, 0, 0, 1),   // call bpf_map_lookup_elem
+		testInsn(0x15, 0, 0, 2, 0),   // if r0 == 0 goto +2
+		testInsn(0xb7, 1, 0, 0, 1),   // r1 = 1
+		testInsn(0xdb, 0, 1, 0, 0),   // lock *(u64 *)(r0 + 0) += r1
+		insnMovR0Imm0,
+		insnExit,
+	}
+	spec := bpfProgSpec{name: name, progType: progType, insns: insns, license: testLicense}
+
+	fd, verr := bpfProgLoad(&spec, LogLevelInstruction, 0)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+
+	prog := &Program{Name: name, Type: progType}
+	prog.setFD(fd)
+
+	return prog, counter
+}
+
+// readTestCounter returns the count of a program loaded with loadTestCounterProg.
+func readTestCounter(t *testing.T, counter *TypedMap[uint32, uint64]) uint64 {
+	t.Helper()
+
+	count, _, err := counter.Lookup(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return count
+}
+
+// testProgID returns the id the kernel gave prog.
+func testProgID(t *testing.T, prog *Program) uint32 {
+	t.Helper()
+
+	info, err := bpfProgGetInfo(prog.FD())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return info.id
+}
+
+// newIteratorTestMap creates a hash map holding the keys {i, 0} with values {i, 0} for i in [0, keys).
+func newIteratorTestMap(t *testing.T, keys int) (*Map, *TypedMap[typedKey, typedValue]) {
+	t.Helper()
+
+	m, err := NewMap(MapTypeHash, 8, 16, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tm, err := NewTypedMap[typedKey, typedValue](m)
+	if err != nil {
+		m.Close()
+		t.Fatal(err)
+	}
+
+	// Key {0, 0} is included on purpose: the iterator must not treat the zero key as "start".
+	for i := 0; i < keys; i++ {
+		if err := tm.Put(typedKey{uint32(i), 0}, typedValue{uint64(i), 0}, UpdateAny); err != nil {
+			m.Close()
+			t.Fatal(err)
+		}
+	}
+
+	return m, tm
+}
+
+// batchTestBuffers returns the keys {i, 0} and values {i, i} for i in [0, n) packed back to back.
+func batchTestBuffers(t *testing.T, n int) ([]byte, []byte) {
+	t.Helper()
+
+	var keys, values []byte
+	for i := 0; i < n; i++ {
+		k, err := marshalBinary(&typedKey{uint32(i), 0}, 8)
+		if err != nil {
+			t.Fatal(err)
+		}
+		v, err := marshalBinary(&typedValue{uint64(i), uint64(i)}, 16)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		keys = append(keys, k...)
+		values = append(values, v...)
+	}
+
+	return keys, values
+}
@@ -0,0 +1,102 @@
+package bpf
+
+import (
+	"debug/elf"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"golang.org/x/sys/unix"
+)
+
+// Ubuntu kernels report their own ABI number as the sublevel in the release, such as 4.15.0-20-generic, and
+// put the upstream version they are based on at the end of this file instead.
+const versionSignatureFile = "/proc/version_signature"
+
+var (
+	kernelVersionOnce sync.Once
+	kernelVersionCode uint32
+)
+
+// kernelVersion returns the LINUX_VERSION_CODE of the running kernel, which kernels before 5.0 require as the
+// kern_version of kprobe programs. It returns 0 if the version can't be worked out.
+func kernelVersion() uint32 {
+	kernelVersionOnce.Do(func() {
+		if data, err := os.ReadFile(versionSignatureFile); err == nil {
+			fields := strings.Fields(string(data))
+			if len(fields) > 0 {
+				if code, err := parseKernelRelease(fields[len(fields)-1]); err == nil {
+					kernelVersionCode = code
+					return
+				}
+			}
+		}
+
+		var uts unix.Utsname
+		if err := unix.Uname(&uts); err != nil {
+			return
+		}
+		if code, err := parseKernelRelease(unix.ByteSliceToString(uts.Release[:])); err == nil {
+			kernelVersionCode = code
+		}
+	})
+
+	return kernelVersionCode
+}
+
+// parseKernelRelease turns a release such as "4.14.123-generic" into KERNEL_VERSION(4, 14, 123). Like the kernel,
+// it caps the sublevel at 255.
+func parseKernelRelease(release string) (uint32, error) {
+	bad := fmt.Errorf("Bad kernel release %q", release)
+
+	if end := strings.IndexFunc(release, func(r rune) bool { return r != '.' && (r < '0' || r > '9') }); end >= 0 {
+		release = release[:end]
+	}
+
+	parts := strings.Split(release, ".")
+	if len(parts) < 2 || len(parts) > 3 {
+		return 0, bad
+	}
+
+	var numbers [3]uint64
+	for i, part := range parts {
+		n, err := strconv.ParseUint(part, 10, 32)
+		if err != nil {
+			return 0, bad
+		}
+		numbers[i] = n
+	}
+	if numbers[0] > 255 || numbers[1] > 255 {
+		return 0, bad
+	}
+	if numbers[2] > 255 {
+		numbers[2] = 255
+	}
+
+	return uint32(numbers[0]<<16 | numbers[1]<<8 | numbers[2]), nil
+}
+
+// bpfElfKernVersion returns the kern_version to load the programs of elfF with: opts.KernelVersion if it is set,
+// otherwise the u32 in the ELF "version" section as iproute2 and libbpf read it. It returns 0 if neither is
+// there, in which case bpfProgLoad uses the running kernel's version for kprobe programs.
+func bpfElfKernVersion(elfF *elf.File, opts *LoadOptions) (uint32, error) {
+	if opts.KernelVersion != 0 {
+		return opts.KernelVersion, nil
+	}
+
+	section := elfF.Section("version")
+	if section == nil {
+		return 0, nil
+	}
+	data, err := section.Data()
+	if err != nil {
+		return 0, err
+	}
+	if len(data) != 4 {
+		return 0, fmt.Errorf("Version section is %d bytes, want 4", len(data))
+	}
+
+	return elfF.ByteOrder.Uint32(data), nil
+}
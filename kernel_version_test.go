@@ -0,0 +1,37 @@
+package bpf
+
+import (
+	"testing"
+)
+
+func TestParseKernelRelease(t *testing.T) {
+	tests := []struct {
+		release string
+		code    uint32
+	}{
+		{"4.14.123-generic", 4<<16 | 14<<8 | 123},
+		{"4.9.337", 4<<16 | 9<<8 | 255},
+		{"5.0", 5 << 16},
+		{"6.18.44-fc-v130", 6<<16 | 18<<8 | 44},
+		{"4.15.0-20-generic", 4<<16 | 15<<8},
+		{"5.10.0+", 5<<16 | 10<<8},
+	}
+
+	for _, test := range tests {
+		if code, err := parseKernelRelease(test.release); err != nil || code != test.code {
+			t.Errorf("Release %s: got %#x (%v), want %#x", test.release, code, err, test.code)
+		}
+	}
+
+	for _, bad := range []string{"", "5", "5.", "x.y.z", "1.2.3.4", "256.0.0"} {
+		if _, err := parseKernelRelease(bad); err == nil {
+			t.Errorf("Release %q should be rejected.", bad)
+		}
+	}
+}
+
+func TestKernelVersion(t *testing.T) {
+	if code := kernelVersion(); code < 4<<16 {
+		t.Fatalf("Running kernel version %#x is implausible", code)
+	}
+}
@@ -0,0 +1,207 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoKprobes is returned when the kernel has neither the kprobe PMU nor tracefs kprobe_events.
+var ErrNoKprobes = errors.New("kernel doesn't support kprobes")
+
+// errNoKprobePMU means the kernel predates the kprobe PMU, so kprobes have to be added through tracefs.
+var errNoKprobePMU = errors.New("no kprobe PMU")
+
+const (
+	kprobePMUDir       = "/sys/bus/event_source/devices/kprobe"
+	kprobeTracefsGroup = "puregobpf"
+
+	kprobeEventSymbolMax = 32 // Leaves room for the kind, pid and sequence number in an event name.
+)
+
+// kprobeEventSeq makes the names of tracefs kprobe events unique within the process.
+var kprobeEventSeq atomic.Uint64
+
+// AttachKprobe attaches prog, which must be a ProgTypeKprobe program, to the entry of the kernel function symbol.
+// It uses the kprobe PMU (Linux 4.17) if the kernel has it and adds a kprobe event through tracefs otherwise;
+// that event is removed again when the Link is closed.
+func AttachKprobe(symbol string, prog *Program) (*Link, error) {
+	return attachKprobe(symbol, prog, false)
+}
+
+// AttachKretprobe is like AttachKprobe but runs prog when symbol returns.
+func AttachKretprobe(symbol string, prog *Program) (*Link, error) {
+	return attachKprobe(symbol, prog, true)
+}
+
+func attachKprobe(symbol string, prog *Program, ret bool) (*Link, error) {
+	if symbol == "" {
+		return nil, errors.New("Attaching a kprobe needs a symbol")
+	}
+
+	fd, err := pmuKprobeOpen(symbol, ret)
+	if errors.Is(err, errNoKprobePMU) {
+		return tracefsKprobeAttach(symbol, prog, ret)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("kprobe %s: %w", symbol, err)
+	}
+
+	return attachPerfEvent(fd, prog, nil)
+}
+
+// pmuKprobeOpen opens a kprobe perf event through the kprobe PMU. It returns errNoKprobePMU if the kernel doesn't
+// have the PMU.
+func pmuKprobeOpen(symbol string, ret bool) (int, error) {
+	pmuType, err := readUintFile(filepath.Join(kprobePMUDir, "type"))
+	if errors.Is(err, os.ErrNotExist) {
+		return -1, errNoKprobePMU
+	}
+	if err != nil {
+		return -1, err
+	}
+
+	attr := unix.PerfEventAttr{Type: uint32(pmuType)}
+	if ret {
+		format, err := os.ReadFile(filepath.Join(kprobePMUDir, "format", "retprobe"))
+		if err != nil {
+			return -1, err
+		}
+		attr.Config, err = parsePMUFormatBit(string(format))
+		if err != nil {
+			return -1, err
+		}
+	}
+
+	// config1 points to the symbol, config2 is the offset into it.
+	name, err := unix.BytePtrFromString(symbol)
+	if err != nil {
+		return -1, err
+	}
+	attr.Ext1 = uint64(uintptr(unsafe.Pointer(name)))
+
+	fd, err := perfEventOpen(&attr)
+	runtime.KeepAlive(name)
+
+	return fd, err
+}
+
+// parsePMUFormatBit parses a PMU format file describing a single bit of config, such as "config:0\n", and
+// returns the mask of that bit.
+func parsePMUFormatBit(format string) (uint64, error) {
+	bit, ok := strings.CutPrefix(strings.TrimSpace(format), "config:")
+	if !ok {
+		return 0, fmt.Errorf("Bad PMU format %q", format)
+	}
+
+	n, err := strconv.ParseUint(bit, 10, 6)
+	if err != nil {
+		return 0, fmt.Errorf("Bad PMU format %q", format)
+	}
+
+	return 1 << n, nil
+}
+
+// tracefsKprobeAttach adds a kprobe event for symbol to kprobe_events and attaches prog to it. Closing the Link
+// removes the event.
+func tracefsKprobeAttach(symbol string, prog *Program, ret bool) (*Link, error) {
+	root, err := tracefsPath()
+	if errors.Is(err, ErrNoTracefs) {
+		return nil, ErrNoKprobes
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	eventsFile := filepath.Join(root, "kprobe_events")
+	if _, err := os.Stat(eventsFile); errors.Is(err, os.ErrNotExist) {
+		return nil, ErrNoKprobes
+	}
+
+	name := kprobeEventName(symbol, ret, os.Getpid(), kprobeEventSeq.Add(1))
+	if err := writeTracefsEvent(eventsFile, kprobeEventLine(symbol, name, ret)); err != nil {
+		return nil, fmt.Errorf("kprobe %s: %w", symbol, err)
+	}
+	remove := func() error {
+		return writeTracefsEvent(eventsFile, "-:"+kprobeTracefsGroup+"/"+name)
+	}
+
+	id, err := tracefsEventID(kprobeTracefsGroup, name)
+	if err != nil {
+		remove()
+		return nil, err
+	}
+
+	fd, err := perfEventOpenTracepoint(id)
+	if err != nil {
+		remove()
+		return nil, fmt.Errorf("kprobe %s: %w", symbol, err)
+	}
+
+	return attachPerfEvent(fd, prog, remove)
+}
+
+// kprobeEventName returns a name for a tracefs kprobe event that is unique to the process and made only of
+// characters tracefs accepts. Symbols such as "foo.isra.0" are cleaned up, and long ones cut short to keep the
+// name within the 64 characters tracefs allows.
+func kprobeEventName(symbol string, ret bool, pid int, seq uint64) string {
+	kind := "p"
+	if ret {
+		kind = "r"
+	}
+
+	clean := strings.Map(func(c rune) rune {
+		if c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_' {
+			return c
+		}
+		return '_'
+	}, symbol)
+	if len(clean) > kprobeEventSymbolMax {
+		clean = clean[:kprobeEventSymbolMax]
+	}
+
+	return fmt.Sprintf("%s_%s_%d_%d", kind, clean, pid, seq)
+}
+
+// kprobeEventLine returns the kprobe_events line that adds the event name for symbol.
+func kprobeEventLine(symbol string, name string, ret bool) string {
+	kind := "p"
+	if ret {
+		kind = "r"
+	}
+
+	return fmt.Sprintf("%s:%s/%s %s", kind, kprobeTracefsGroup, name, symbol)
+}
+
+// writeTracefsEvent appends line to a tracefs events file such as kprobe_events.
+func writeTracefsEvent(file string, line string) error {
+	f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		return err
+	}
+
+	_, err = f.WriteString(line + "\n")
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+
+	return err
+}
+
+// readUintFile reads a file holding a decimal number, such as a PMU type.
+func readUintFile(file string) (uint64, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return 0, err
+	}
+
+	return strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+}
@@ -0,0 +1,96 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestParsePMUFormatBit(t *testing.T) {
+	if mask, err := parsePMUFormatBit("config:0\n"); err != nil || mask != 1 {
+		t.Fatal("Bad mask:", mask, err)
+	}
+	if mask, err := parsePMUFormatBit("config:63"); err != nil || mask != 1<<63 {
+		t.Fatal("Bad mask:", mask, err)
+	}
+
+	for _, format := range []string{"", "config1:0", "config:64", "config:0-7"} {
+		if _, err := parsePMUFormatBit(format); err == nil {
+			t.Errorf("Format %q should be rejected.", format)
+		}
+	}
+}
+
+func TestKprobeEventName(t *testing.T) {
+	name := kprobeEventName("tcp_v4_connect.isra.0", true, 42, 7)
+	if name != "r_tcp_v4_connect_isra_0_42_7" {
+		t.Fatal("Bad event name:", name)
+	}
+	if !tracefsValidName(name) {
+		t.Fatal("Event name should be valid:", name)
+	}
+
+	if line := kprobeEventLine("tcp_v4_connect", "p_tcp_v4_connect_42_7", false); line !=
+		"p:puregobpf/p_tcp_v4_connect_42_7 tcp_v4_connect" {
+		t.Fatal("Bad kprobe_events line:", line)
+	}
+
+	long := kprobeEventName(strings.Repeat("x", 200), false, 4194304, 1<<63)
+	if len(long) > 64 {
+		t.Fatal("Event name is too long:", long)
+	}
+}
+
+// readTestFile makes a few read(2) calls, which go through vfs_read.
+func readTestFile(t *testing.T) {
+	t.Helper()
+
+	for i := 0; i < 3; i++ {
+		if _, err := os.ReadFile("/proc/self/stat"); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestKprobe(t *testing.T) {
+	prog, counter := loadTestCounterProg(t, "kprobe", ProgTypeKprobe)
+	defer prog.Close()
+
+	for _, attach := range []func(string, *Program) (*Link, error){AttachKprobe, AttachKretprobe} {
+		if err := counter.Put(0, 0, UpdateAny); err != nil {
+			t.Fatal(err)
+		}
+
+		link, err := attach("vfs_read", prog)
+		if errors.Is(err, ErrNoKprobes) {
+			t.Skip("Kernel doesn't support kprobes.")
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		readTestFile(t)
+		if count := readTestCounter(t, counter); count == 0 {
+			t.Fatal("The program didn't run on vfs_read.")
+		}
+
+		if err := link.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := link.Close(); err != nil {
+			t.Fatal("Closing twice should do nothing:", err)
+		}
+
+		// Once the link is closed, the probe is gone and the count stays put.
+		count := readTestCounter(t, counter)
+		readTestFile(t)
+		if after := readTestCounter(t, counter); after != count {
+			t.Fatal("The program still ran after the link was closed:", count, after)
+		}
+	}
+
+	if _, err := AttachKprobe("puregobpf_no_such_symbol", prog); err == nil {
+		t.Fatal("Attaching to a missing symbol should fail.")
+	}
+}
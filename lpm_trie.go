@@ -0,0 +1,232 @@
+package bpf
+
+import (
+	"fmt"
+	"net"
+	"net/netip"
+	"runtime"
+	"unsafe"
+)
+
+// Flags for creating maps.
+const (
+	MapFlagNoPrealloc = 1 // Allocate elements on demand (BPF_F_NO_PREALLOC). Required for LPM tries.
+)
+
+// LPM trie keys are struct bpf_lpm_trie_key: a host order u32 prefix length followed by the address bytes in
+// network order.
+const (
+	lpmKeySizeIPv4 = 4 + net.IPv4len
+	lpmKeySizeIPv6 = 4 + net.IPv6len
+)
+
+// NewLPMTrieMap creates an LPM trie map for IPv4 or IPv6 prefixes with valueSize byte values.
+func NewLPMTrieMap(ipv6 bool, valueSize uint32, maxEntries uint32) (*Map, error) {
+	keySize := uint32(lpmKeySizeIPv4)
+	if ipv6 {
+		keySize = lpmKeySizeIPv6
+	}
+
+	return NewMap(MapTypeLpmTrie, keySize, valueSize, maxEntries, MapFlagNoPrealloc)
+}
+
+// LPMTrie wraps an LPM trie map of IPv4 or IPv6 prefixes with values of type V. Values are marshalled like
+// TypedMap does.
+type LPMTrie[V any] struct {
+	m       *Map
+	addrLen int
+}
+
+// NewLPMTrie wraps m, which must be an LPM trie with 8 byte (IPv4) or 20 byte (IPv6) keys and values the size
+// of V.
+func NewLPMTrie[V any](m *Map) (*LPMTrie[V], error) {
+	if m.Type != MapTypeLpmTrie {
+		return nil, fmt.Errorf("Map %s isn't an LPM trie", m.Name)
+	}
+	if m.KeySize != lpmKeySizeIPv4 && m.KeySize != lpmKeySizeIPv6 {
+		return nil, fmt.Errorf("Map %s has %d byte keys, which isn't an IPv4 or IPv6 prefix", m.Name, m.KeySize)
+	}
+
+	valueSize, err := binarySize[V]()
+	if err != nil {
+		return nil, err
+	}
+	if valueSize != int(m.ValueSize) {
+		return nil, fmt.Errorf("Map %s has %d byte values but the value type is %d bytes", m.Name, m.ValueSize,
+			valueSize)
+	}
+
+	return &LPMTrie[V]{m: m, addrLen: int(m.KeySize) - 4}, nil
+}
+
+// Map returns the underlying map.
+func (t *LPMTrie[V]) Map() *Map {
+	return t.m
+}
+
+// Insert sets the value of prefix. Host bits of prefix are ignored.
+func (t *LPMTrie[V]) Insert(prefix netip.Prefix, value V) error {
+	keyBuf, err := t.prefixKey(prefix)
+	if err != nil {
+		return err
+	}
+	valueBuf, err := marshalBinary(&value, int(t.m.ValueSize))
+	if err != nil {
+		return err
+	}
+
+	err = bpfMapUpdateElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])),
+		UpdateAny)
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(t.m)
+
+	return withName(err, t.m.Name)
+}
+
+// InsertIPNet is Insert for a *net.IPNet.
+func (t *LPMTrie[V]) InsertIPNet(ipNet *net.IPNet, value V) error {
+	prefix, err := ipNetPrefix(ipNet)
+	if err != nil {
+		return err
+	}
+
+	return t.Insert(prefix, value)
+}
+
+// Delete removes prefix. It returns false if prefix wasn't present. Only the exact prefix is removed, not the
+// prefixes it covers.
+func (t *LPMTrie[V]) Delete(prefix netip.Prefix) (bool, error) {
+	keyBuf, err := t.prefixKey(prefix)
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := bpfMapDeleteElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(t.m)
+
+	return deleted, withName(err, t.m.Name)
+}
+
+// DeleteIPNet is Delete for a *net.IPNet.
+func (t *LPMTrie[V]) DeleteIPNet(ipNet *net.IPNet) (bool, error) {
+	prefix, err := ipNetPrefix(ipNet)
+	if err != nil {
+		return false, err
+	}
+
+	return t.Delete(prefix)
+}
+
+// Lookup returns the value of the longest prefix containing addr. The bool is false if no prefix matches.
+func (t *LPMTrie[V]) Lookup(addr netip.Addr) (V, bool, error) {
+	var value V
+
+	keyBuf, err := t.prefixKey(netip.PrefixFrom(addr, addr.BitLen()))
+	if err != nil {
+		return value, false, err
+	}
+	valueBuf := make([]byte, t.m.ValueSize)
+
+	found, err := bpfMapLookupElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(t.m)
+	if err != nil || !found {
+		return value, false, withName(err, t.m.Name)
+	}
+
+	if err := unmarshalBinary(valueBuf, &value); err != nil {
+		return value, false, err
+	}
+
+	return value, true, nil
+}
+
+// LookupIP is Lookup for a net.IP.
+func (t *LPMTrie[V]) LookupIP(ip net.IP) (V, bool, error) {
+	addr, ok := netip.AddrFromSlice(ip)
+	if !ok {
+		var value V
+		return value, false, fmt.Errorf("Bad IP address %v", ip)
+	}
+
+	return t.Lookup(addr)
+}
+
+// Prefixes returns every prefix in the trie with its value. It needs kernel 4.16 or later.
+func (t *LPMTrie[V]) Prefixes() (map[netip.Prefix]V, error) {
+	prefixes := make(map[netip.Prefix]V)
+
+	keyBuf := make([]byte, t.m.KeySize)
+	var value V
+
+	it := t.m.Iterate()
+	for it.Next(keyBuf, &value) {
+		prefix, err := t.keyPrefix(keyBuf)
+		if err != nil {
+			return nil, err
+		}
+
+		prefixes[prefix] = value
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return prefixes, nil
+}
+
+// prefixKey encodes prefix as a struct bpf_lpm_trie_key for this trie.
+func (t *LPMTrie[V]) prefixKey(prefix netip.Prefix) ([]byte, error) {
+	if !prefix.IsValid() {
+		return nil, fmt.Errorf("Bad prefix %v", prefix)
+	}
+
+	addr := prefix.Addr()
+	bits := prefix.Bits()
+	if addr.Is4In6() && t.addrLen == net.IPv4len {
+		addr = addr.Unmap()
+		bits -= 96
+	}
+	if addr.BitLen() != t.addrLen*8 || bits < 0 {
+		return nil, fmt.Errorf("Prefix %v doesn't fit map %s of %d bit addresses", prefix, t.m.Name, t.addrLen*8)
+	}
+
+	prefix = netip.PrefixFrom(addr, bits).Masked()
+
+	keyBuf := make([]byte, t.m.KeySize)
+	nativeEndian.PutUint32(keyBuf, uint32(bits))
+	copy(keyBuf[4:], prefix.Addr().AsSlice())
+
+	return keyBuf, nil
+}
+
+// keyPrefix decodes a struct bpf_lpm_trie_key.
+func (t *LPMTrie[V]) keyPrefix(keyBuf []byte) (netip.Prefix, error) {
+	addr, ok := netip.AddrFromSlice(keyBuf[4:])
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("Bad LPM trie key %x", keyBuf)
+	}
+
+	return netip.PrefixFrom(addr, int(nativeEndian.Uint32(keyBuf))), nil
+}
+
+// ipNetPrefix converts a *net.IPNet to a netip.Prefix.
+func ipNetPrefix(ipNet *net.IPNet) (netip.Prefix, error) {
+	addr, ok := netip.AddrFromSlice(ipNet.IP)
+	if !ok {
+		return netip.Prefix{}, fmt.Errorf("Bad prefix %v", ipNet)
+	}
+
+	ones, bits := ipNet.Mask.Size()
+	if bits == 0 {
+		return netip.Prefix{}, fmt.Errorf("Bad prefix mask %v", ipNet)
+	}
+	if bits == 32 && addr.Is4In6() {
+		addr = addr.Unmap()
+	}
+
+	return netip.PrefixFrom(addr, ones), nil
+}
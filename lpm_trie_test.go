@@ -0,0 +1,147 @@
+package bpf
+
+import (
+	"net"
+	"net/netip"
+	"testing"
+)
+
+func TestLPMTrieIPv4(t *testing.T) {
+	m, err := NewLPMTrieMap(false, 4, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	trie, err := NewLPMTrie[uint32](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), 8); err != nil {
+		t.Fatal(err)
+	}
+	// Host bits are masked off.
+	if err := trie.Insert(netip.MustParsePrefix("10.1.2.3/16"), 16); err != nil {
+		t.Fatal(err)
+	}
+	_, ipNet, _ := net.ParseCIDR("10.1.2.0/24")
+	if err := trie.InsertIPNet(ipNet, 24); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		addr  string
+		value uint32
+		found bool
+	}{
+		{"10.1.2.3", 24, true},
+		{"10.1.3.3", 16, true},
+		{"10.2.0.1", 8, true},
+		{"::ffff:10.2.0.1", 8, true},
+		{"192.168.0.1", 0, false},
+	}
+	for _, test := range tests {
+		value, found, err := trie.Lookup(netip.MustParseAddr(test.addr))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if found != test.found || value != test.value {
+			t.Errorf("%s: got %d %v, want %d %v", test.addr, value, found, test.value, test.found)
+		}
+	}
+
+	value, found, err := trie.LookupIP(net.ParseIP("10.1.2.200"))
+	if err != nil || !found || value != 24 {
+		t.Fatal("Bad LookupIP:", value, found, err)
+	}
+
+	if _, _, err := trie.Lookup(netip.MustParseAddr("2001:db8::1")); err == nil {
+		t.Fatal("IPv6 address in an IPv4 trie should be rejected.")
+	}
+
+	prefixes, err := trie.Prefixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[netip.Prefix]uint32{
+		netip.MustParsePrefix("10.0.0.0/8"):  8,
+		netip.MustParsePrefix("10.1.0.0/16"): 16,
+		netip.MustParsePrefix("10.1.2.0/24"): 24,
+	}
+	if len(prefixes) != len(want) {
+		t.Fatal("Bad prefixes:", prefixes)
+	}
+	for prefix, value := range want {
+		if prefixes[prefix] != value {
+			t.Fatal("Bad prefixes:", prefixes)
+		}
+	}
+
+	deleted, err := trie.DeleteIPNet(ipNet)
+	if err != nil || !deleted {
+		t.Fatal("Delete:", deleted, err)
+	}
+	deleted, err = trie.DeleteIPNet(ipNet)
+	if err != nil || deleted {
+		t.Fatal("Second delete:", deleted, err)
+	}
+
+	value, found, err = trie.Lookup(netip.MustParseAddr("10.1.2.3"))
+	if err != nil || !found || value != 16 {
+		t.Fatal("Lookup after delete:", value, found, err)
+	}
+}
+
+func TestLPMTrieIPv6(t *testing.T) {
+	m, err := NewLPMTrieMap(true, 8, 16)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	trie, err := NewLPMTrie[uint64](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := trie.Insert(netip.MustParsePrefix("2001:db8::/32"), 32); err != nil {
+		t.Fatal(err)
+	}
+	if err := trie.Insert(netip.MustParsePrefix("2001:db8:1::/48"), 48); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := trie.Lookup(netip.MustParseAddr("2001:db8:1::1"))
+	if err != nil || !found || value != 48 {
+		t.Fatal("Bad lookup:", value, found, err)
+	}
+	value, found, err = trie.Lookup(netip.MustParseAddr("2001:db8:2::1"))
+	if err != nil || !found || value != 32 {
+		t.Fatal("Bad lookup:", value, found, err)
+	}
+
+	if err := trie.Insert(netip.MustParsePrefix("10.0.0.0/8"), 8); err == nil {
+		t.Fatal("IPv4 prefix in an IPv6 trie should be rejected.")
+	}
+
+	prefixes, err := trie.Prefixes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(prefixes) != 2 || prefixes[netip.MustParsePrefix("2001:db8:1::/48")] != 48 {
+		t.Fatal("Bad prefixes:", prefixes)
+	}
+}
+
+func TestNewLPMTrieChecksMap(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 4, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewLPMTrie[uint32](m); err == nil {
+		t.Fatal("Hash map should be rejected.")
+	}
+}
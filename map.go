@@ -0,0 +1,172 @@
+package bpf
+
+import (
+	"runtime"
+
+	"golang.org/x/sys/unix"
+)
+
+// Map is an eBPF map that has been created in the kernel.
+//
+// A Map owns its file descriptor. Call Close once the map is no longer needed; a finalizer closes the fd if the
+// Map is garbage collected first, but relying on it leaks kernel memory for an unpredictable amount of time.
+//
+// Wrappers such as TypedMap, LPMTrie, ProgArray and CgroupArray don't own the Map they wrap, so closing it is
+// still up to whoever created or loaded it.
+type Map struct {
+	Name       string // Symbol name of the map in the ELF file.
+	Section    string // ELF section the map definition was read from.
+	Type       MapType
+	KeySize    uint32
+	ValueSize  uint32
+	MaxEntries uint32
+	Flags      uint32
+	PinPath    string // Where the loader pinned the map, or found it pinned, if its definition asks for pinning.
+
+	fd int
+}
+
+// NewMap creates a new map in the kernel.
+func NewMap(mapType MapType, keySize uint32, valueSize uint32, maxEntries uint32, flags uint32) (*Map, error) {
+	fd, err := BpfCreateMap(uint32(mapType), keySize, valueSize, maxEntries, flags)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Map{
+		Type:       mapType,
+		KeySize:    keySize,
+		ValueSize:  valueSize,
+		MaxEntries: maxEntries,
+		Flags:      flags,
+	}
+	m.setFD(fd)
+
+	return m, nil
+}
+
+// setFD hands ownership of fd to the map and arms the finalizer.
+func (m *Map) setFD(fd int) {
+	m.fd = fd
+	runtime.SetFinalizer(m, (*Map).Close)
+}
+
+// FD returns the file descriptor of the map. It returns -1 once the map has been closed.
+func (m *Map) FD() int {
+	return m.fd
+}
+
+// Close releases the file descriptor of the map. Closing an already closed map does nothing.
+func (m *Map) Close() error {
+	if m.fd < 0 {
+		return nil
+	}
+
+	runtime.SetFinalizer(m, nil)
+	err := unix.Close(m.fd)
+	m.fd = -1
+
+	return err
+}
+
+// Clone returns a new Map referring to the same kernel object through a duplicated file descriptor. The clone
+// must be closed independently of the original.
+func (m *Map) Clone() (*Map, error) {
+	fd, err := dupFD(m.fd)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *m
+	clone.setFD(fd)
+
+	return &clone, nil
+}
+
+// Lookup looks key up in the map and stores the result in entry. It returns false if key isn't present. It
+// refuses per-CPU maps, whose values don't fit into entry; use TypedMap.LookupPerCPU for those.
+func (m *Map) Lookup(key MapKey, entry MapEntry) (bool, error) {
+	if m.isPerCPU() {
+		return false, errPerCPU
+	}
+
+	found, err := BpfMapLookupElem(m.fd, key, entry)
+	return found, withName(err, m.Name)
+}
+
+// Update sets the value of key in the map to entry. See BpfMapUpdateElem for flags. It refuses per-CPU maps;
+// use TypedMap.PutPerCPU for those.
+func (m *Map) Update(key MapKey, entry MapEntry, flags uint32) (bool, error) {
+	if m.isPerCPU() {
+		return false, errPerCPU
+	}
+
+	updated, err := BpfMapUpdateElem(m.fd, key, entry, flags)
+	return updated, withName(err, m.Name)
+}
+
+// Delete removes key from the map. It returns false if key wasn't present.
+func (m *Map) Delete(key MapKey) (bool, error) {
+	deleted, err := BpfMapDeleteElem(m.fd, key)
+	return deleted, withName(err, m.Name)
+}
+
+// NextKey stores the key following key in result. See BpfMapGetNextKey.
+func (m *Map) NextKey(key MapKey, result MapKey) (bool, error) {
+	more, err := BpfMapGetNextKey(m.fd, key, result)
+	return more, withName(err, m.Name)
+}
+
+// Pin pins the map to pathname on a bpf filesystem, creating missing parent directories, and sets PinPath.
+func (m *Map) Pin(pathname string) error {
+	if err := BpfObjPin(m.fd, pathname); err != nil {
+		return err
+	}
+
+	m.PinPath = pathname
+	return nil
+}
+
+// Unpin removes the pin at PinPath. The map stays usable through its fd. Unpinning a map that isn't pinned does
+// nothing.
+func (m *Map) Unpin() error {
+	if m.PinPath == "" {
+		return nil
+	}
+
+	if err := BpfObjUnpin(m.PinPath); err != nil {
+		return err
+	}
+
+	m.PinPath = ""
+	return nil
+}
+
+// LoadPinnedMap opens the map pinned at pathname. The definition of the returned Map is read from the kernel; its
+// Name is the name the map was created with, which may be cut short. The Map must be closed by the caller.
+func LoadPinnedMap(pathname string) (*Map, error) {
+	fd, err := BpfObjGet(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := bpfPinnedMapInfo(fd, pathname)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	m := newMapFromInfo(fd, info)
+	m.PinPath = pathname
+
+	return m, nil
+}
+
+// dupFD duplicates fd with close-on-exec set, as the kernel does for every bpf fd it hands out.
+func dupFD(fd int) (int, error) {
+	if fd < 0 {
+		return -1, unix.EBADF
+	}
+
+	return unix.FcntlInt(uintptr(fd), unix.F_DUPFD_CLOEXEC, 0)
+}
@@ -0,0 +1,333 @@
+package bpf
+
+import (
+	"fmt"
+	"runtime"
+	"sync"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// errnoENOTSUPP is the kernel internal ENOTSUPP, which leaks to userspace from some map types that don't
+// implement the batch operations. It isn't the same as ENOTSUP/EOPNOTSUPP.
+const errnoENOTSUPP = unix.Errno(524)
+
+// batchSupport caches whether the kernel has a batch command for a map type, keyed by batchSupportKey.
+var batchSupport sync.Map
+
+type batchSupportKey struct {
+	cmd     Cmd
+	mapType MapType
+}
+
+// BatchCursor holds the position of BatchLookup and BatchLookupAndDelete between calls. The zero value starts at
+// the beginning of the map. A cursor must only be used with one map.
+type BatchCursor struct {
+	token    []byte // Opaque position handed back to the kernel as in_batch.
+	started  bool
+	done     bool
+	fallback bool   // The kernel doesn't support batches so the cursor walks keys one at a time.
+	lastKey  []byte // Position of the fallback walk.
+}
+
+// Done reports whether the whole map has been visited.
+func (c *BatchCursor) Done() bool {
+	return c.done
+}
+
+// BatchLookup copies up to len(keys)/KeySize elements, starting from cursor, into the keys and values buffers,
+// which hold the keys and values back to back. Values of per-CPU maps take one 8 byte aligned value per possible
+// CPU each. It returns the number of elements copied and advances cursor;
+// cursor.Done() reports when the end of the map has been reached. A typical drain loop is:
+//
+//	var cursor BatchCursor
+//	for !cursor.Done() {
+//		n, err := m.BatchLookup(&cursor, keys, values)
+//		...
+//	}
+//
+// Hash maps return whole buckets, so the buffers must have room for at least the largest bucket; otherwise the
+// error matches unix.ENOSPC. On kernels without BPF_MAP_LOOKUP_BATCH (before 5.6) it falls back to
+// BPF_MAP_GET_NEXT_KEY and BPF_MAP_LOOKUP_ELEM.
+func (m *Map) BatchLookup(cursor *BatchCursor, keys []byte, values []byte) (int, error) {
+	return m.batchLookup(bpfCmdMapLookupBatch, cursor, keys, values)
+}
+
+// BatchLookupAndDelete is like BatchLookup but deletes the elements it returns.
+func (m *Map) BatchLookupAndDelete(cursor *BatchCursor, keys []byte, values []byte) (int, error) {
+	return m.batchLookup(bpfCmdMapLookupAndDeleteBatch, cursor, keys, values)
+}
+
+// BatchUpdate sets the elements whose keys and values are stored back to back in keys and values. flags is
+// UpdateAny or UpdateLock; the kernel rejects the other update flags for batches with EINVAL. It returns the
+// number of elements updated, which is less than requested if an error occurred part way through.
+func (m *Map) BatchUpdate(keys []byte, values []byte, flags uint32) (int, error) {
+	count, err := m.batchCount(keys, values)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	attrs := bpfMapBatchAttr{}
+	attrs.mapFd = uint32(m.fd)
+	attrs.keys = bpfPtr(unsafe.Pointer(&keys[0]))
+	attrs.values = bpfPtr(unsafe.Pointer(&values[0]))
+	attrs.count = uint32(count)
+	attrs.elemFlags = uint64(flags)
+
+	_, serr := bpfSyscall(bpfCmdMapUpdateBatch, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(values)
+	runtime.KeepAlive(m)
+	if m.isBatchUnsupported(bpfCmdMapUpdateBatch, serr) {
+		return m.batchUpdateFallback(keys, values, count, flags)
+	}
+	if serr != 0 {
+		return batchDoneCount(serr, attrs.count), withName(newError(bpfCmdMapUpdateBatch, serr, m.fd), m.Name)
+	}
+
+	return int(attrs.count), nil
+}
+
+// BatchDelete deletes the elements whose keys are stored back to back in keys. It returns the number of elements
+// deleted. If a key isn't present, the deletion stops there and the error matches ErrKeyNotExist.
+func (m *Map) BatchDelete(keys []byte) (int, error) {
+	count, err := m.batchCount(keys, nil)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	attrs := bpfMapBatchAttr{}
+	attrs.mapFd = uint32(m.fd)
+	attrs.keys = bpfPtr(unsafe.Pointer(&keys[0]))
+	attrs.count = uint32(count)
+
+	_, serr := bpfSyscall(bpfCmdMapDeleteBatch, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(m)
+	if m.isBatchUnsupported(bpfCmdMapDeleteBatch, serr) {
+		return m.batchDeleteFallback(keys, count)
+	}
+	if serr != 0 {
+		return batchDoneCount(serr, attrs.count), withName(newError(bpfCmdMapDeleteBatch, serr, m.fd), m.Name)
+	}
+
+	return int(attrs.count), nil
+}
+
+func (m *Map) batchLookup(cmd Cmd, cursor *BatchCursor, keys []byte, values []byte) (int, error) {
+	if cursor.done {
+		return 0, nil
+	}
+
+	count, err := m.batchCount(keys, values)
+	if err != nil || count == 0 {
+		return 0, err
+	}
+
+	if cursor.fallback {
+		return m.batchLookupFallback(cmd, cursor, keys, values, count)
+	}
+
+	// Hash maps use a u32 bucket index as the position, other maps a key.
+	tokenSize := int(m.KeySize)
+	if tokenSize < 4 {
+		tokenSize = 4
+	}
+	if len(cursor.token) != tokenSize {
+		cursor.token = make([]byte, tokenSize)
+	}
+
+	attrs := bpfMapBatchAttr{}
+	attrs.mapFd = uint32(m.fd)
+	attrs.keys = bpfPtr(unsafe.Pointer(&keys[0]))
+	attrs.values = bpfPtr(unsafe.Pointer(&values[0]))
+	attrs.count = uint32(count)
+	if cursor.started {
+		attrs.inBatch = bpfPtr(unsafe.Pointer(&cursor.token[0]))
+	}
+	attrs.outBatch = bpfPtr(unsafe.Pointer(&cursor.token[0]))
+
+	_, serr := bpfSyscall(cmd, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(values)
+	runtime.KeepAlive(cursor)
+	runtime.KeepAlive(m)
+	if !cursor.started && m.isBatchUnsupported(cmd, serr) {
+		cursor.fallback = true
+		return m.batchLookupFallback(cmd, cursor, keys, values, count)
+	}
+
+	switch serr {
+	case 0:
+		cursor.started = true
+		return int(attrs.count), nil
+	case unix.ENOENT:
+		// The end of the map. The last elements are still copied out.
+		cursor.done = true
+		return int(attrs.count), nil
+	default:
+		return 0, withName(newError(cmd, serr, m.fd), m.Name)
+	}
+}
+
+// batchCount checks that keys (and values, unless nil) hold the same whole number of elements and returns it.
+func (m *Map) batchCount(keys []byte, values []byte) (int, error) {
+	if m.KeySize == 0 || len(keys)%int(m.KeySize) != 0 {
+		return 0, fmt.Errorf("Keys buffer of %d bytes isn't a multiple of the %d byte key size", len(keys), m.KeySize)
+	}
+
+	count := len(keys) / int(m.KeySize)
+	if values == nil {
+		return count, nil
+	}
+
+	valueSize, err := m.valueBufSize()
+	if err != nil {
+		return 0, err
+	}
+	if len(values) != count*valueSize {
+		return 0, fmt.Errorf("Values buffer of %d bytes doesn't match %d keys of %d byte values", len(values),
+			count, valueSize)
+	}
+
+	return count, nil
+}
+
+// batchDoneCount returns how many elements an update or delete batch that failed with errno got through. The
+// kernel writes the count back once it has started on the elements, but rejects bad arguments with EINVAL before
+// that, leaving the requested count in place.
+func batchDoneCount(errno unix.Errno, count uint32) int {
+	if errno == unix.EINVAL {
+		return 0
+	}
+
+	return int(count)
+}
+
+// isBatchUnsupported reports whether errno, returned by cmd, means the kernel or the map type doesn't support
+// the command. Map types without batches return ENOTSUPP, but kernels without the command return EINVAL, which
+// also means bad arguments, so the command is probed to tell the two apart.
+func (m *Map) isBatchUnsupported(cmd Cmd, errno unix.Errno) bool {
+	switch errno {
+	case errnoENOTSUPP:
+		return true
+	case unix.EINVAL:
+		return !m.batchSupported(cmd)
+	}
+
+	return false
+}
+
+// batchSupported reports whether the kernel has cmd for the type of the map. It asks once per command and map
+// type with a count of zero, which kernels that have the command accept without doing anything.
+func (m *Map) batchSupported(cmd Cmd) bool {
+	key := batchSupportKey{cmd, m.Type}
+	if supported, ok := batchSupport.Load(key); ok {
+		return supported.(bool)
+	}
+
+	attrs := bpfMapBatchAttr{}
+	attrs.mapFd = uint32(m.fd)
+
+	_, serr := bpfSyscall(cmd, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(m)
+	supported := serr != unix.EINVAL && serr != errnoENOTSUPP
+	batchSupport.Store(key, supported)
+
+	return supported
+}
+
+// batchLookupFallback fills keys and values one element at a time. For lookups it continues from the last key
+// returned; if that key is deleted in the meantime the kernel starts over and elements may be returned twice.
+// For lookup and delete it always takes the first key since every returned key is removed.
+func (m *Map) batchLookupFallback(cmd Cmd, cursor *BatchCursor, keys []byte, values []byte, count int) (int, error) {
+	keySize, valueSize := int(m.KeySize), len(values)/count
+	deleting := cmd == bpfCmdMapLookupAndDeleteBatch
+
+	n := 0
+	for n < count {
+		key := keys[n*keySize : (n+1)*keySize]
+		value := values[n*valueSize : (n+1)*valueSize]
+
+		var prev uint64
+		if cursor.lastKey != nil && !deleting {
+			prev = bpfPtr(unsafe.Pointer(&cursor.lastKey[0]))
+		}
+
+		more, err := bpfMapGetNextKey(m.fd, prev, bpfPtr(unsafe.Pointer(&key[0])))
+		runtime.KeepAlive(cursor.lastKey)
+		runtime.KeepAlive(m)
+		if err != nil {
+			return n, withName(err, m.Name)
+		}
+		if !more {
+			cursor.done = true
+			break
+		}
+
+		found, err := bpfMapLookupElem(m.fd, bpfPtr(unsafe.Pointer(&key[0])), bpfPtr(unsafe.Pointer(&value[0])))
+		runtime.KeepAlive(m)
+		if err != nil {
+			return n, withName(err, m.Name)
+		}
+		if deleting {
+			_, err := bpfMapDeleteElem(m.fd, bpfPtr(unsafe.Pointer(&key[0])))
+			runtime.KeepAlive(m)
+			if err != nil {
+				return n, withName(err, m.Name)
+			}
+		}
+
+		if !found {
+			// Deleted since we got the key. Asking for the key after lastKey again skips it.
+			continue
+		}
+
+		cursor.lastKey = append(cursor.lastKey[:0], key...)
+		n++
+	}
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(values)
+
+	return n, nil
+}
+
+func (m *Map) batchUpdateFallback(keys []byte, values []byte, count int, flags uint32) (int, error) {
+	keySize, valueSize := int(m.KeySize), len(values)/count
+
+	for n := 0; n < count; n++ {
+		key := keys[n*keySize : (n+1)*keySize]
+		value := values[n*valueSize : (n+1)*valueSize]
+
+		err := bpfMapUpdateElem(m.fd, bpfPtr(unsafe.Pointer(&key[0])), bpfPtr(unsafe.Pointer(&value[0])), flags)
+		runtime.KeepAlive(m)
+		if err != nil {
+			return n, withName(err, m.Name)
+		}
+	}
+	runtime.KeepAlive(keys)
+	runtime.KeepAlive(values)
+
+	return count, nil
+}
+
+func (m *Map) batchDeleteFallback(keys []byte, count int) (int, error) {
+	keySize := int(m.KeySize)
+
+	for n := 0; n < count; n++ {
+		key := keys[n*keySize : (n+1)*keySize]
+
+		deleted, err := bpfMapDeleteElem(m.fd, bpfPtr(unsafe.Pointer(&key[0])))
+		runtime.KeepAlive(m)
+		if err != nil {
+			return n, withName(err, m.Name)
+		}
+		if !deleted {
+			return n, withName(newError(bpfCmdMapDeleteElem, unix.ENOENT, m.fd), m.Name)
+		}
+	}
+	runtime.KeepAlive(keys)
+
+	return count, nil
+}
@@ -0,0 +1,168 @@
+package bpf
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+const batchTestElems = 10
+
+// drainBatches reads the whole map with lookup (or lookup and delete if del is set) and returns the values by key.
+func drainBatches(t *testing.T, m *Map, cursor *BatchCursor, del bool) map[typedKey]typedValue {
+	t.Helper()
+
+	keys := make([]byte, 64*8)
+	values := make([]byte, 64*16)
+	elems := make(map[typedKey]typedValue)
+
+	for !cursor.Done() {
+		var n int
+		var err error
+		if del {
+			n, err = m.BatchLookupAndDelete(cursor, keys, values)
+		} else {
+			n, err = m.BatchLookup(cursor, keys, values)
+		}
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		for i := 0; i < n; i++ {
+			var k typedKey
+			var v typedValue
+			if err := unmarshalBinary(keys[i*8:(i+1)*8], &k); err != nil {
+				t.Fatal(err)
+			}
+			if err := unmarshalBinary(values[i*16:(i+1)*16], &v); err != nil {
+				t.Fatal(err)
+			}
+			elems[k] = v
+		}
+	}
+
+	return elems
+}
+
+func testBatchOperations(t *testing.T, fallback bool) {
+	m, err := NewMap(MapTypeHash, 8, 16, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	keys, values := batchTestBuffers(t, batchTestElems)
+
+	// The update and delete fallbacks only run on kernels without batches, so call them directly.
+	batchUpdate := m.BatchUpdate
+	batchDelete := m.BatchDelete
+	if fallback {
+		batchUpdate = func(keys []byte, values []byte, flags uint32) (int, error) {
+			return m.batchUpdateFallback(keys, values, len(keys)/8, flags)
+		}
+		batchDelete = func(keys []byte) (int, error) {
+			return m.batchDeleteFallback(keys, len(keys)/8)
+		}
+	}
+
+	n, err := batchUpdate(keys, values, UpdateAny)
+	if err != nil || n != batchTestElems {
+		t.Fatal("BatchUpdate:", n, err)
+	}
+
+	elems := drainBatches(t, m, &BatchCursor{fallback: fallback}, false)
+	if len(elems) != batchTestElems || elems[typedKey{7, 0}] != (typedValue{7, 7}) {
+		t.Fatal("Bad lookup result:", elems)
+	}
+
+	// Delete the first two keys, then fail on a key that is gone.
+	n, err = batchDelete(keys[:2*8])
+	if err != nil || n != 2 {
+		t.Fatal("BatchDelete:", n, err)
+	}
+	if _, err := batchDelete(keys[:8]); !errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("Expected ErrKeyNotExist, got:", err)
+	}
+
+	elems = drainBatches(t, m, &BatchCursor{fallback: fallback}, true)
+	if len(elems) != batchTestElems-2 {
+		t.Fatal("Wrong number of elements drained:", len(elems))
+	}
+
+	elems = drainBatches(t, m, &BatchCursor{fallback: fallback}, false)
+	if len(elems) != 0 {
+		t.Fatal("Map should be empty:", elems)
+	}
+}
+
+func TestBatchOperations(t *testing.T) {
+	testBatchOperations(t, false)
+}
+
+func TestBatchOperationsFallback(t *testing.T) {
+	testBatchOperations(t, true)
+}
+
+func TestBatchBadFlags(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if !m.batchSupported(bpfCmdMapUpdateBatch) {
+		t.Skip("Kernel doesn't have BPF_MAP_UPDATE_BATCH.")
+	}
+
+	// The kernel rejects UpdateNoExist for batches. That must not be mistaken for missing batch support and
+	// done one element at a time instead.
+	keys, values := batchTestBuffers(t, batchTestElems)
+	n, err := m.BatchUpdate(keys, values, UpdateNoExist)
+	if !errors.Is(err, unix.EINVAL) || n != 0 {
+		t.Fatal("Bad flags should fail with EINVAL:", n, err)
+	}
+
+	elems := drainBatches(t, m, &BatchCursor{}, false)
+	if len(elems) != 0 {
+		t.Fatal("Nothing should have been updated:", elems)
+	}
+}
+
+func TestBatchSupported(t *testing.T) {
+	array, err := NewMap(MapTypeArray, 4, 8, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer array.Close()
+
+	if !array.batchSupported(bpfCmdMapLookupBatch) {
+		t.Skip("Kernel doesn't have BPF_MAP_LOOKUP_BATCH.")
+	}
+
+	// Arrays can't delete elements, so they have no lookup and delete batch.
+	if array.batchSupported(bpfCmdMapLookupAndDeleteBatch) {
+		t.Fatal("Arrays shouldn't support BPF_MAP_LOOKUP_AND_DELETE_BATCH.")
+	}
+	if !array.isBatchUnsupported(bpfCmdMapLookupAndDeleteBatch, unix.EINVAL) {
+		t.Fatal("EINVAL from an unsupported command should mean unsupported.")
+	}
+	if array.isBatchUnsupported(bpfCmdMapLookupBatch, unix.EINVAL) {
+		t.Fatal("EINVAL from a supported command should be reported as an error.")
+	}
+}
+
+func TestBatchBufferSizes(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 64, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := m.BatchLookup(&BatchCursor{}, make([]byte, 12), make([]byte, 16)); err == nil {
+		t.Fatal("Partial key should be rejected.")
+	}
+	if _, err := m.BatchUpdate(make([]byte, 16), make([]byte, 16), UpdateAny); err == nil {
+		t.Fatal("Missing value should be rejected.")
+	}
+}
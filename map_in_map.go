@@ -0,0 +1,190 @@
+package bpf
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// isMapInMap reports whether maps of type mapType hold other maps.
+func isMapInMap(mapType MapType) bool {
+	return mapType == MapTypeArrayOfMaps || mapType == MapTypeHashOfMaps
+}
+
+// NewMapInMap creates an array of maps or a hash of maps. Every map put into it must have the same type, key size,
+// value size and max entries as inner, which only serves as a template; inner isn't put into the new map.
+func NewMapInMap(mapType MapType, keySize uint32, maxEntries uint32, flags uint32, inner *Map) (*Map, error) {
+	if !isMapInMap(mapType) {
+		return nil, fmt.Errorf("Map type %d can't hold maps", mapType)
+	}
+
+	attrs := bpfMapCreateAttr{}
+	attrs.mapType = uint32(mapType)
+	attrs.keySize = keySize
+	attrs.valueSize = 4 // Map fds going in, map ids coming out.
+	attrs.maxEntries = maxEntries
+	attrs.mapFlags = flags
+	attrs.innerMapFd = uint32(inner.fd)
+
+	fd, err := bpfMapCreate(&attrs)
+	runtime.KeepAlive(inner)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Map{
+		Type:       mapType,
+		KeySize:    keySize,
+		ValueSize:  attrs.valueSize,
+		MaxEntries: maxEntries,
+		Flags:      flags,
+	}
+	m.setFD(fd)
+
+	return m, nil
+}
+
+// SetInnerMap puts inner into the map-in-map m at key, which must be KeySize bytes; for arrays of maps that is a
+// u32 index in the host's byte order. See BpfMapUpdateElem for flags. Replacing an inner map is atomic for BPF
+// programs looking it up. m holds its own reference to inner, so inner can be closed afterwards.
+func (m *Map) SetInnerMap(key []byte, inner *Map, flags uint32) error {
+	if !isMapInMap(m.Type) {
+		return fmt.Errorf("Map %s doesn't hold maps", m.Name)
+	}
+	if len(key) != int(m.KeySize) {
+		return fmt.Errorf("Key of %d bytes doesn't match the %d byte key size of map %s", len(key), m.KeySize, m.Name)
+	}
+
+	err := mapInMapSet(m.fd, key, inner.fd, flags)
+	runtime.KeepAlive(inner)
+	runtime.KeepAlive(m)
+
+	return withName(err, m.Name)
+}
+
+// InnerMapID returns the kernel id of the inner map at key. The bool is false if key isn't present.
+func (m *Map) InnerMapID(key []byte) (uint32, bool, error) {
+	if !isMapInMap(m.Type) {
+		return 0, false, fmt.Errorf("Map %s doesn't hold maps", m.Name)
+	}
+	if len(key) != int(m.KeySize) {
+		return 0, false, fmt.Errorf("Key of %d bytes doesn't match the %d byte key size of map %s", len(key),
+			m.KeySize, m.Name)
+	}
+
+	valueBuf := make([]byte, 4)
+	found, err := bpfMapLookupElem(m.fd, bpfPtr(unsafe.Pointer(&key[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])))
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(m)
+	if err != nil || !found {
+		return 0, false, withName(err, m.Name)
+	}
+
+	return nativeEndian.Uint32(valueBuf), true, nil
+}
+
+// InnerMap opens the inner map at key. The bool is false if key isn't present. The returned Map has its own file
+// descriptor and must be closed by the caller.
+func (m *Map) InnerMap(key []byte) (*Map, bool, error) {
+	id, found, err := m.InnerMapID(key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	fd, err := bpfMapGetFDByID(id)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			// Replaced and freed since the lookup.
+			return nil, false, nil
+		}
+		return nil, false, err
+	}
+
+	inner, err := newMapFromFD(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, false, err
+	}
+
+	return inner, true, nil
+}
+
+// mapInMapSet puts the map innerFd into the map-in-map fd at key.
+func mapInMapSet(fd int, key []byte, innerFd int, flags uint32) error {
+	valueBuf := make([]byte, 4)
+	nativeEndian.PutUint32(valueBuf, uint32(innerFd))
+
+	err := bpfMapUpdateElem(fd, bpfPtr(unsafe.Pointer(&key[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])), flags)
+	runtime.KeepAlive(key)
+	runtime.KeepAlive(valueBuf)
+
+	return err
+}
+
+// bpfMapGetFDByID opens the map with the passed kernel id.
+func bpfMapGetFDByID(id uint32) (int, error) {
+	attrs := bpfGetFDByIDAttr{}
+	attrs.id = id
+
+	r1, serr := bpfSyscall(bpfCmdMapGetFdByID, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	if serr != 0 {
+		return -1, newError(bpfCmdMapGetFdByID, serr, -1)
+	}
+
+	return int(r1), nil
+}
+
+// bpfMapGetInfo asks the kernel for the definition of the map fd.
+func bpfMapGetInfo(fd int) (*bpfMapInfo, error) {
+	info := bpfMapInfo{}
+
+	attrs := bpfObjInfoAttr{}
+	attrs.bpfFd = uint32(fd)
+	attrs.infoLen = uint32(unsafe.Sizeof(info))
+	attrs.info = bpfPtr(unsafe.Pointer(&info))
+
+	_, serr := bpfSyscall(bpfCmdObjGetInfoByFd, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(&info)
+	if serr != 0 {
+		return nil, newError(bpfCmdObjGetInfoByFd, serr, fd)
+	}
+
+	return &info, nil
+}
+
+// newMapFromFD builds a Map around fd, filling in the definition from the kernel. The Map takes ownership of fd
+// only if it succeeds.
+func newMapFromFD(fd int) (*Map, error) {
+	info, err := bpfMapGetInfo(fd)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMapFromInfo(fd, info), nil
+}
+
+// newMapFromInfo builds a Map around fd from its definition and takes ownership of fd.
+func newMapFromInfo(fd int, info *bpfMapInfo) *Map {
+
+	name := info.name[:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	m := &Map{
+		Name:       string(name),
+		Type:       MapType(info.mapType),
+		KeySize:    info.keySize,
+		ValueSize:  info.valueSize,
+		MaxEntries: info.maxEntries,
+		Flags:      info.mapFlags,
+	}
+	m.setFD(fd)
+
+	return m
+}
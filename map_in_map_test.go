@@ -0,0 +1,103 @@
+package bpf
+
+import (
+	"testing"
+)
+
+func arrayKey(index uint32) []byte {
+	key := make([]byte, 4)
+	nativeEndian.PutUint32(key, index)
+
+	return key
+}
+
+func TestLoadMapInMap(t *testing.T) {
+	coll, err := BpfLoadProg("bpf/map_in_map.o", []string{"classifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	outer := coll.Maps["outer_map"]
+	if outer.Type != MapTypeArrayOfMaps {
+		t.Fatal("Bad outer map:", outer)
+	}
+
+	// The template has inner_idx 0 so it was put into slot 0.
+	templateInfo, err := bpfMapGetInfo(coll.Maps["inner_map"].FD())
+	if err != nil {
+		t.Fatal(err)
+	}
+	id, found, err := outer.InnerMapID(arrayKey(0))
+	if err != nil || !found || id != templateInfo.id {
+		t.Fatal("Slot 0 should hold the template:", id, found, err)
+	}
+	if _, found, err := outer.InnerMapID(arrayKey(1)); err != nil || found {
+		t.Fatal("Slot 1 should be empty:", found, err)
+	}
+}
+
+func TestMapInMap(t *testing.T) {
+	template, err := NewMap(MapTypeHash, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer template.Close()
+
+	outer, err := NewMapInMap(MapTypeArrayOfMaps, 4, 4, 0, template)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer outer.Close()
+
+	tenant, err := NewMap(MapTypeHash, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tm, err := NewTypedMap[uint32, uint64](tenant)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Put(7, 42, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := outer.SetInnerMap(arrayKey(2), tenant, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+	// The outer map keeps the inner map alive.
+	tenant.Close()
+
+	inner, found, err := outer.InnerMap(arrayKey(2))
+	if err != nil || !found {
+		t.Fatal("Slot 2 should hold the tenant map:", found, err)
+	}
+	defer inner.Close()
+
+	if inner.Type != MapTypeHash || inner.KeySize != 4 || inner.ValueSize != 8 || inner.MaxEntries != 16 {
+		t.Fatal("Bad inner map definition:", inner)
+	}
+
+	tm, err = NewTypedMap[uint32, uint64](inner)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := tm.Lookup(7)
+	if err != nil || !found || value != 42 {
+		t.Fatal("Bad lookup through the inner map:", value, found, err)
+	}
+
+	// Maps that don't match the template are rejected by the kernel.
+	other, err := NewMap(MapTypeArray, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer other.Close()
+	if err := outer.SetInnerMap(arrayKey(3), other, UpdateAny); err == nil {
+		t.Fatal("Map not matching the template should be rejected.")
+	}
+
+	if _, _, err := template.InnerMapID(arrayKey(0)); err == nil {
+		t.Fatal("InnerMapID on a hash map should fail.")
+	}
+}
@@ -0,0 +1,165 @@
+package bpf
+
+import (
+	"errors"
+	"runtime"
+	"unsafe"
+)
+
+// ErrIterationAborted is returned by MapIterator.Err when the map changed so much during the iteration that it
+// kept restarting from the beginning.
+var ErrIterationAborted = errors.New("iteration aborted after too many restarts")
+
+// maxIteratorRestarts bounds how often a MapIterator starts over before giving up.
+const maxIteratorRestarts = 16
+
+// MapIterator walks over the elements of a map. Keys and values are decoded with encoding/binary like TypedMap
+// does. Use it like this:
+//
+//	it := m.Iterate()
+//	for it.Next(&key, &value) {
+//		...
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+//
+// BPF_MAP_GET_NEXT_KEY starts over from the first key when the key it is passed has been deleted, which happens
+// when the datapath or another process deletes elements during the iteration. MapIterator remembers the keys it
+// has returned and skips them after a restart, so every key is returned at most once. Elements deleted between
+// reading the key and reading the value are skipped.
+type MapIterator struct {
+	// MaxEntries caps the number of elements returned. Zero means no limit.
+	MaxEntries int
+
+	m        *Map
+	prevKey  []byte
+	nextKey  []byte
+	value    []byte
+	seen     map[string]struct{}
+	count    int
+	restarts int
+	skipping bool // Walking past keys already returned after a restart.
+	done     bool
+	err      error
+}
+
+// Iterate returns an iterator over the elements of the map.
+func (m *Map) Iterate() *MapIterator {
+	it := &MapIterator{
+		m:       m,
+		nextKey: make([]byte, m.KeySize),
+		seen:    make(map[string]struct{}),
+	}
+
+	bufSize, err := m.valueBufSize()
+	if err != nil {
+		it.fail(err)
+		return it
+	}
+	it.value = make([]byte, bufSize)
+
+	return it
+}
+
+// Next decodes the next element into key and value, which must be pointers to fixed size types. For per-CPU maps
+// value must point to a slice, which is set to one value per possible CPU. value may be nil to only read keys. It
+// returns false when there are no more elements or an error occurred; check Err to tell the two apart.
+func (it *MapIterator) Next(key interface{}, value interface{}) bool {
+	if it.done {
+		return false
+	}
+	if it.MaxEntries > 0 && it.count >= it.MaxEntries {
+		it.done = true
+		return false
+	}
+
+	for {
+		// A nil previous key asks the kernel for the first key of the map.
+		var prev uint64
+		if it.prevKey != nil {
+			prev = bpfPtr(unsafe.Pointer(&it.prevKey[0]))
+		}
+
+		more, err := bpfMapGetNextKey(it.m.fd, prev, bpfPtr(unsafe.Pointer(&it.nextKey[0])))
+		runtime.KeepAlive(it.prevKey)
+		runtime.KeepAlive(it.nextKey)
+		runtime.KeepAlive(it.m)
+		if err != nil {
+			return it.fail(withName(err, it.m.Name))
+		}
+		if !more {
+			it.done = true
+			return false
+		}
+
+		if _, ok := it.seen[string(it.nextKey)]; ok {
+			// The previous key was deleted so the kernel started over. Walk past the keys already returned.
+			if !it.skipping {
+				it.skipping = true
+				it.restarts++
+				if it.restarts > maxIteratorRestarts {
+					return it.fail(ErrIterationAborted)
+				}
+			}
+			it.prevKey = append(it.prevKey[:0], it.nextKey...)
+			continue
+		}
+
+		if value != nil {
+			found, err := bpfMapLookupElem(it.m.fd, bpfPtr(unsafe.Pointer(&it.nextKey[0])),
+				bpfPtr(unsafe.Pointer(&it.value[0])))
+			runtime.KeepAlive(it.nextKey)
+			runtime.KeepAlive(it.value)
+			runtime.KeepAlive(it.m)
+			if err != nil {
+				return it.fail(withName(err, it.m.Name))
+			}
+			if !found {
+				// Deleted since we got the key. Asking for the key after prevKey again skips it.
+				continue
+			}
+		}
+
+		if err := unmarshalBinary(it.nextKey, key); err != nil {
+			return it.fail(err)
+		}
+		if value != nil {
+			if err := it.unmarshalValue(value); err != nil {
+				return it.fail(err)
+			}
+		}
+
+		it.seen[string(it.nextKey)] = struct{}{}
+		it.skipping = false
+		it.prevKey = append(it.prevKey[:0], it.nextKey...)
+		it.count++
+
+		return true
+	}
+}
+
+func (it *MapIterator) unmarshalValue(value interface{}) error {
+	if it.m.isPerCPU() {
+		return unmarshalPerCPU(it.value, it.m.perCPUStride(), value)
+	}
+
+	return unmarshalBinary(it.value, value)
+}
+
+func (it *MapIterator) fail(err error) bool {
+	it.err = err
+	it.done = true
+
+	return false
+}
+
+// Err returns the error that stopped the iteration, if any.
+func (it *MapIterator) Err() error {
+	return it.err
+}
+
+// Restarts returns how often the kernel started the iteration over because the current key was deleted.
+func (it *MapIterator) Restarts() int {
+	return it.restarts
+}
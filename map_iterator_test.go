@@ -0,0 +1,102 @@
+package bpf
+
+import (
+	"testing"
+)
+
+func TestMapIterator(t *testing.T) {
+	m, _ := newIteratorTestMap(t, 10)
+	defer m.Close()
+
+	var k typedKey
+	var v typedValue
+	seen := make(map[typedKey]bool)
+
+	it := m.Iterate()
+	for it.Next(&k, &v) {
+		if seen[k] {
+			t.Fatal("Key returned twice:", k)
+		}
+		if uint64(k.A) != v.ValueA {
+			t.Fatal("Value doesn't belong to key:", k, v)
+		}
+		seen[k] = true
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 10 || !seen[typedKey{0, 0}] {
+		t.Fatal("Wrong keys:", seen)
+	}
+}
+
+func TestMapIteratorEmpty(t *testing.T) {
+	m, _ := newIteratorTestMap(t, 0)
+	defer m.Close()
+
+	var k typedKey
+
+	it := m.Iterate()
+	if it.Next(&k, nil) {
+		t.Fatal("Empty map returned a key:", k)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestMapIteratorMaxEntries(t *testing.T) {
+	m, _ := newIteratorTestMap(t, 10)
+	defer m.Close()
+
+	var k typedKey
+	count := 0
+
+	it := m.Iterate()
+	it.MaxEntries = 4
+	for it.Next(&k, nil) {
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if count != 4 {
+		t.Fatal("Wrong number of keys:", count)
+	}
+}
+
+func TestMapIteratorDeleteDuringIteration(t *testing.T) {
+	m, tm := newIteratorTestMap(t, 10)
+	defer m.Close()
+
+	var k typedKey
+	var v typedValue
+	seen := make(map[typedKey]bool)
+
+	// Deleting the current key makes the kernel start over from the first key on the next step.
+	it := m.Iterate()
+	for it.Next(&k, &v) {
+		if seen[k] {
+			t.Fatal("Key returned twice:", k)
+		}
+		seen[k] = true
+
+		if len(seen)%3 == 0 {
+			if _, err := tm.Delete(k); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := it.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 10 {
+		t.Fatal("Wrong number of keys:", len(seen))
+	}
+	if it.Restarts() == 0 {
+		t.Fatal("Restarts should have been detected.")
+	}
+}
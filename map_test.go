@@ -0,0 +1,93 @@
+package bpf
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestMapCloneClose(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	clone, err := m.Clone()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer clone.Close()
+
+	if clone.FD() == m.FD() {
+		t.Fatal("Clone should have its own fd.")
+	}
+
+	// The kernel object must survive the original being closed.
+	if err := m.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if m.FD() != -1 {
+		t.Fatal("Closed map should have fd -1:", m.FD())
+	}
+	if err := m.Close(); err != nil {
+		t.Fatal("Closing twice should be a no-op:", err)
+	}
+
+	myKey := key{111, 222}
+	myEntry := entry{8888, 9999}
+	if _, err := clone.Update(&myKey, &myEntry, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := clone.Lookup(&myKey, &myEntry)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !found {
+		t.Fatal("Key should be present in the clone.")
+	}
+
+	if _, err := m.Clone(); err == nil {
+		t.Fatal("Cloning a closed map should fail.")
+	}
+}
+
+func TestMapUpdateFlags(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	myKey := key{111, 222}
+	myEntry := entry{8888, 9999}
+
+	// Update-only writes fail while the key is missing.
+	if _, err := m.Update(&myKey, &myEntry, UpdateExist); !errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("Expected ErrKeyNotExist, got:", err)
+	}
+
+	if _, err := m.Update(&myKey, &myEntry, UpdateNoExist); err != nil {
+		t.Fatal(err)
+	}
+
+	// Insert-only writes fail once the key is present.
+	_, err = m.Update(&myKey, &myEntry, UpdateNoExist)
+	if !errors.Is(err, ErrKeyExist) || !errors.Is(err, unix.EEXIST) {
+		t.Fatal("Expected ErrKeyExist, got:", err)
+	}
+	if errors.Is(err, ErrKeyNotExist) {
+		t.Fatal("Error shouldn't match ErrKeyNotExist:", err)
+	}
+
+	myEntry.valueA = 1
+	if _, err := m.Update(&myKey, &myEntry, UpdateExist); err != nil {
+		t.Fatal(err)
+	}
+
+	found, err := m.Lookup(&myKey, &myEntry)
+	if err != nil || !found || myEntry.valueA != 1 {
+		t.Fatal("Update didn't take effect:", found, err, myEntry)
+	}
+}
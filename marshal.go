@@ -0,0 +1,68 @@
+package bpf
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"reflect"
+)
+
+// binarySize returns the number of bytes encoding/binary uses for a value of type T. It fails for types that
+// don't have a fixed size, such as slices, maps, strings, int and uint, and for structs with unexported fields,
+// which encoding/binary can't decode into.
+func binarySize[T any]() (int, error) {
+	var v T
+
+	typ := reflect.TypeOf(&v).Elem()
+	if err := checkBinaryType(typ); err != nil {
+		return 0, err
+	}
+
+	size := binary.Size(&v)
+	if size < 0 {
+		return 0, fmt.Errorf("%s doesn't have a fixed size", typ)
+	}
+
+	return size, nil
+}
+
+func checkBinaryType(typ reflect.Type) error {
+	switch typ.Kind() {
+	case reflect.Slice:
+		return fmt.Errorf("%s doesn't have a fixed size", typ)
+	case reflect.Array:
+		return checkBinaryType(typ.Elem())
+	case reflect.Struct:
+		for i := 0; i < typ.NumField(); i++ {
+			field := typ.Field(i)
+			if field.Name != "_" && !field.IsExported() {
+				return fmt.Errorf("%s has unexported field %s", typ, field.Name)
+			}
+			if err := checkBinaryType(field.Type); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// marshalBinary encodes v into a buffer of size bytes in the host's byte order, which is how the kernel stores
+// map keys and values. Blank (_) fields are written as zeroes so padding can be declared explicitly.
+func marshalBinary(v any, size int) ([]byte, error) {
+	buf := bytes.NewBuffer(make([]byte, 0, size))
+
+	if err := binary.Write(buf, nativeEndian, v); err != nil {
+		return nil, err
+	}
+	if buf.Len() != size {
+		return nil, fmt.Errorf("%T marshals to %d bytes, want %d", v, buf.Len(), size)
+	}
+
+	return buf.Bytes(), nil
+}
+
+// unmarshalBinary decodes buf into the value pointed to by v.
+func unmarshalBinary(buf []byte, v any) error {
+	return binary.Read(bytes.NewReader(buf), nativeEndian, v)
+}
@@ -0,0 +1,221 @@
+package bpf
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+
+	"golang.org/x/sys/unix"
+)
+
+// This file has just enough of an rtnetlink client to attach programs to interfaces. Netlink messages and
+// attributes are in the host's byte order.
+
+const (
+	netlinkRecvBufSize = 1 << 16
+	netlinkAlignTo     = 4
+	nlaHeaderLen       = 4
+	nlaFNested         = 1 << 15
+	nlaTypeMask        = ^uint16(1<<15 | 1<<14)
+	nlmFCapped         = 0x100
+)
+
+// NetlinkError is returned when the kernel rejects a netlink request.
+type NetlinkError struct {
+	Op      string     // What the request was doing, e.g. "add filter".
+	Errno   unix.Errno // The error the kernel returned.
+	Message string     // Extended ack message explaining the error, if the kernel sent one.
+}
+
+func (e *NetlinkError) Error() string {
+	if e.Message != "" {
+		return fmt.Sprintf("netlink %s: %s: %s", e.Op, e.Errno, e.Message)
+	}
+
+	return fmt.Sprintf("netlink %s: %s", e.Op, e.Errno)
+}
+
+// Unwrap returns the errno so errors.Is(err, unix.EEXIST) and friends work.
+func (e *NetlinkError) Unwrap() error {
+	return e.Errno
+}
+
+// netlinkConn is a NETLINK_ROUTE socket. It belongs to the network namespace of the thread that opened it.
+type netlinkConn struct {
+	fd  int
+	seq uint32
+}
+
+func dialNetlink() (*netlinkConn, error) {
+	fd, err := unix.Socket(unix.AF_NETLINK, unix.SOCK_RAW|unix.SOCK_CLOEXEC, unix.NETLINK_ROUTE)
+	if err != nil {
+		return nil, os.NewSyscallError("socket", err)
+	}
+
+	if err := unix.Bind(fd, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		unix.Close(fd)
+		return nil, os.NewSyscallError("bind", err)
+	}
+
+	// Extended acks explain why a request was rejected. Kernels before 4.12 don't have them, which is fine.
+	unix.SetsockoptInt(fd, unix.SOL_NETLINK, unix.NETLINK_EXT_ACK, 1)
+
+	return &netlinkConn{fd: fd}, nil
+}
+
+func (c *netlinkConn) Close() error {
+	return unix.Close(c.fd)
+}
+
+// request sends a message of msgType with body and returns the bodies of the messages the kernel answers with.
+// NLM_F_REQUEST and NLM_F_ACK are added to flags. op names the request in errors.
+func (c *netlinkConn) request(op string, msgType uint16, flags uint16, body []byte) ([][]byte, error) {
+	c.seq++
+
+	msg := make([]byte, unix.SizeofNlMsghdr, unix.SizeofNlMsghdr+len(body))
+	nativeEndian.PutUint32(msg[0:], uint32(unix.SizeofNlMsghdr+len(body)))
+	nativeEndian.PutUint16(msg[4:], msgType)
+	nativeEndian.PutUint16(msg[6:], flags|unix.NLM_F_REQUEST|unix.NLM_F_ACK)
+	nativeEndian.PutUint32(msg[8:], c.seq)
+	msg = append(msg, body...)
+
+	if err := unix.Sendto(c.fd, msg, 0, &unix.SockaddrNetlink{Family: unix.AF_NETLINK}); err != nil {
+		return nil, os.NewSyscallError("sendto", err)
+	}
+
+	// Dumps end with NLMSG_DONE, everything else with an ack.
+	dump := flags&unix.NLM_F_DUMP == unix.NLM_F_DUMP
+
+	var replies [][]byte
+	buf := make([]byte, netlinkRecvBufSize)
+	for {
+		n, _, err := unix.Recvfrom(c.fd, buf, 0)
+		if err != nil {
+			return nil, os.NewSyscallError("recvfrom", err)
+		}
+
+		for data := buf[:n]; len(data) >= unix.SizeofNlMsghdr; {
+			msgLen := int(nativeEndian.Uint32(data[0:]))
+			if msgLen < unix.SizeofNlMsghdr || msgLen > len(data) {
+				return nil, fmt.Errorf("netlink %s: truncated message", op)
+			}
+			replyType := nativeEndian.Uint16(data[4:])
+			replyFlags := nativeEndian.Uint16(data[6:])
+			seq := nativeEndian.Uint32(data[8:])
+			payload := data[unix.SizeofNlMsghdr:msgLen]
+			if next := netlinkAlign(msgLen); next < len(data) {
+				data = data[next:]
+			} else {
+				data = nil
+			}
+
+			if seq != c.seq {
+				// A late answer to an earlier request.
+				continue
+			}
+
+			switch replyType {
+			case unix.NLMSG_ERROR:
+				if len(payload) < 4 {
+					return nil, fmt.Errorf("netlink %s: truncated error", op)
+				}
+				errno := -int32(nativeEndian.Uint32(payload))
+				if errno == 0 {
+					return replies, nil
+				}
+				return nil, &NetlinkError{
+					Op:      op,
+					Errno:   unix.Errno(errno),
+					Message: netlinkExtAckMessage(payload, replyFlags),
+				}
+			case unix.NLMSG_DONE:
+				if dump {
+					return replies, nil
+				}
+			default:
+				replies = append(replies, append([]byte(nil), payload...))
+			}
+		}
+	}
+}
+
+// netlinkExtAckMessage returns the NLMSGERR_ATTR_MSG of an error message, if it has one. The attributes follow
+// the header of the rejected request, and its body too unless the kernel capped it.
+func netlinkExtAckMessage(payload []byte, flags uint16) string {
+	if flags&unix.NLM_F_ACK_TLVS == 0 || len(payload) < 4+unix.SizeofNlMsghdr {
+		return ""
+	}
+
+	offset := 4 + unix.SizeofNlMsghdr
+	if flags&nlmFCapped == 0 {
+		offset = 4 + int(nativeEndian.Uint32(payload[4:]))
+	}
+	if offset > len(payload) {
+		return ""
+	}
+
+	return netlinkString(parseNetlinkAttrs(payload[offset:])[unix.NLMSGERR_ATTR_MSG])
+}
+
+func netlinkAlign(n int) int {
+	return (n + netlinkAlignTo - 1) &^ (netlinkAlignTo - 1)
+}
+
+// netlinkAttrs builds a sequence of netlink attributes.
+type netlinkAttrs struct {
+	buf []byte
+}
+
+func (a *netlinkAttrs) add(typ uint16, data []byte) {
+	hdr := make([]byte, nlaHeaderLen)
+	nativeEndian.PutUint16(hdr[0:], uint16(nlaHeaderLen+len(data)))
+	nativeEndian.PutUint16(hdr[2:], typ)
+
+	a.buf = append(a.buf, hdr...)
+	a.buf = append(a.buf, data...)
+	a.buf = append(a.buf, make([]byte, netlinkAlign(len(data))-len(data))...)
+}
+
+func (a *netlinkAttrs) addU32(typ uint16, v uint32) {
+	data := make([]byte, 4)
+	nativeEndian.PutUint32(data, v)
+	a.add(typ, data)
+}
+
+func (a *netlinkAttrs) addString(typ uint16, s string) {
+	a.add(typ, append([]byte(s), 0))
+}
+
+func (a *netlinkAttrs) addNested(typ uint16, nested *netlinkAttrs) {
+	a.add(typ|nlaFNested, nested.buf)
+}
+
+// parseNetlinkAttrs splits b into attributes by type. If a type occurs more than once, the last one wins.
+func parseNetlinkAttrs(b []byte) map[uint16][]byte {
+	attrs := make(map[uint16][]byte)
+
+	for len(b) >= nlaHeaderLen {
+		attrLen := int(nativeEndian.Uint16(b[0:]))
+		if attrLen < nlaHeaderLen || attrLen > len(b) {
+			break
+		}
+
+		attrs[nativeEndian.Uint16(b[2:])&nlaTypeMask] = b[nlaHeaderLen:attrLen]
+
+		if netlinkAlign(attrLen) > len(b) {
+			break
+		}
+		b = b[netlinkAlign(attrLen):]
+	}
+
+	return attrs
+}
+
+// netlinkString decodes a NUL terminated string attribute.
+func netlinkString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
@@ -0,0 +1,53 @@
+package bpf
+
+import (
+	"net"
+	"runtime"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// enterTestNetns moves the test into a new network namespace holding a veth pair and returns the ifindex of one
+// end. The goroutine stays locked to its thread, so the thread, and the namespace with it, goes away when the test
+// ends.
+func enterTestNetns(t *testing.T) int {
+	t.Helper()
+
+	runtime.LockOSThread()
+	if err := unix.Unshare(unix.CLONE_NEWNET); err != nil {
+		t.Skip("Can't create a network namespace:", err)
+	}
+
+	// veth0 <-> veth1. The peer is described by a struct ifinfomsg followed by its own attributes.
+	peer := netlinkAttrs{}
+	peer.addString(unix.IFLA_IFNAME, "veth1")
+	peerData := netlinkAttrs{}
+	peerData.add(1 /* VETH_INFO_PEER */, append(make([]byte, unix.SizeofIfInfomsg), peer.buf...))
+
+	linkInfo := netlinkAttrs{}
+	linkInfo.addString(unix.IFLA_INFO_KIND, "veth")
+	linkInfo.addNested(unix.IFLA_INFO_DATA, &peerData)
+
+	attrs := netlinkAttrs{}
+	attrs.addString(unix.IFLA_IFNAME, "veth0")
+	attrs.addNested(unix.IFLA_LINKINFO, &linkInfo)
+
+	conn, err := dialNetlink()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	msg := append(make([]byte, unix.SizeofIfInfomsg), attrs.buf...)
+	if _, err := conn.request("add veth", unix.RTM_NEWLINK, unix.NLM_F_CREATE|unix.NLM_F_EXCL, msg); err != nil {
+		t.Skip("Can't create a veth pair:", err)
+	}
+
+	iface, err := net.InterfaceByName("veth0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return iface.Index
+}
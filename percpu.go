@@ -0,0 +1,181 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+const possibleCPUsFile = "/sys/devices/system/cpu/possible"
+
+var possibleCPUs struct {
+	once sync.Once
+	n    int
+	err  error
+}
+
+// PossibleCPUs returns the number of possible CPUs, which is the number of values the kernel stores for each
+// element of a per-CPU map. It can be larger than the number of online CPUs.
+func PossibleCPUs() (int, error) {
+	possibleCPUs.once.Do(func() {
+		data, err := os.ReadFile(possibleCPUsFile)
+		if err != nil {
+			possibleCPUs.err = err
+			return
+		}
+
+		possibleCPUs.n, possibleCPUs.err = parseCPURange(string(data))
+	})
+
+	return possibleCPUs.n, possibleCPUs.err
+}
+
+// parseCPURange parses a CPU list like "0-3" or "0,2-5" and returns the highest CPU number plus one.
+func parseCPURange(cpus string) (int, error) {
+	n := 0
+
+	for _, r := range strings.Split(strings.TrimSpace(cpus), ",") {
+		last := r
+		if i := strings.IndexByte(r, '-'); i >= 0 {
+			last = r[i+1:]
+		}
+
+		cpu, err := strconv.Atoi(last)
+		if err != nil {
+			return 0, fmt.Errorf("Bad CPU list %q: %s", cpus, err)
+		}
+		if cpu+1 > n {
+			n = cpu + 1
+		}
+	}
+
+	return n, nil
+}
+
+// isPerCPU reports whether the map stores one value per possible CPU.
+func (m *Map) isPerCPU() bool {
+	switch m.Type {
+	case MapTypePerCpuHash, MapTypePerCpuArray, MapTypeLruPerCpuHash:
+		return true
+	}
+
+	return false
+}
+
+// perCPUStride is the space each CPU's value takes in a per-CPU value buffer. The kernel aligns values to 8 bytes.
+func (m *Map) perCPUStride() int {
+	return (int(m.ValueSize) + 7) &^ 7
+}
+
+// valueBufSize returns the number of bytes the kernel reads or writes for one value of the map: ValueSize for
+// ordinary maps and one 8 byte aligned value per possible CPU for per-CPU maps.
+func (m *Map) valueBufSize() (int, error) {
+	if !m.isPerCPU() {
+		return int(m.ValueSize), nil
+	}
+
+	cpus, err := PossibleCPUs()
+	if err != nil {
+		return 0, err
+	}
+
+	return m.perCPUStride() * cpus, nil
+}
+
+// errPerCPU is returned by the single value accessors when they are used on a per-CPU map, whose values would
+// overflow the buffer.
+var errPerCPU = errors.New("Map stores a value per CPU; use the PerCPU accessors")
+
+// LookupPerCPU returns the value of key on every possible CPU, indexed by CPU number. The bool is false if key
+// isn't present. The map must be a per-CPU map.
+func (t *TypedMap[K, V]) LookupPerCPU(key K) ([]V, bool, error) {
+	if !t.m.isPerCPU() {
+		return nil, false, fmt.Errorf("Map %s isn't a per-CPU map", t.m.Name)
+	}
+
+	valueBuf, found, err := t.lookupBuf(key)
+	if err != nil || !found {
+		return nil, false, err
+	}
+
+	var values []V
+	if err := unmarshalPerCPU(valueBuf, t.m.perCPUStride(), &values); err != nil {
+		return nil, false, err
+	}
+
+	return values, true, nil
+}
+
+// PutPerCPU sets the value of key on every possible CPU. values must have one entry per possible CPU. See
+// BpfMapUpdateElem for flags.
+func (t *TypedMap[K, V]) PutPerCPU(key K, values []V, flags uint32) error {
+	if !t.m.isPerCPU() {
+		return fmt.Errorf("Map %s isn't a per-CPU map", t.m.Name)
+	}
+
+	bufSize, err := t.m.valueBufSize()
+	if err != nil {
+		return err
+	}
+
+	stride := t.m.perCPUStride()
+	if len(values)*stride != bufSize {
+		return fmt.Errorf("Got %d values but there are %d possible CPUs", len(values), bufSize/stride)
+	}
+
+	valueBuf := make([]byte, bufSize)
+	for cpu := range values {
+		buf, err := marshalBinary(&values[cpu], int(t.m.ValueSize))
+		if err != nil {
+			return err
+		}
+		copy(valueBuf[cpu*stride:], buf)
+	}
+
+	return t.putBuf(key, valueBuf, flags)
+}
+
+// unmarshalPerCPU decodes a per-CPU value buffer into values, which must point to a slice. Each CPU's value
+// starts stride bytes after the previous one.
+func unmarshalPerCPU(buf []byte, stride int, values interface{}) error {
+	slice := reflect.ValueOf(values).Elem()
+	n := len(buf) / stride
+	slice.Set(reflect.MakeSlice(slice.Type(), n, n))
+
+	for cpu := 0; cpu < n; cpu++ {
+		if err := unmarshalBinary(buf[cpu*stride:(cpu+1)*stride], slice.Index(cpu).Addr().Interface()); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// number is the set of types SumPerCPU can add up.
+type number interface {
+	~int8 | ~int16 | ~int32 | ~int64 | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~float32 | ~float64
+}
+
+// SumPerCPU adds up the per-CPU values of a counter.
+func SumPerCPU[T number](values []T) T {
+	var sum T
+	for _, v := range values {
+		sum += v
+	}
+
+	return sum
+}
+
+// MergePerCPU folds the per-CPU values of a struct into one with merge, e.g. to add up several counters at once.
+func MergePerCPU[V any](values []V, merge func(acc V, v V) V) V {
+	var acc V
+	for _, v := range values {
+		acc = merge(acc, v)
+	}
+
+	return acc
+}
@@ -0,0 +1,112 @@
+package bpf
+
+import (
+	"testing"
+)
+
+func TestParseCPURange(t *testing.T) {
+	tests := []struct {
+		cpus string
+		n    int
+	}{
+		{"0\n", 1},
+		{"0-3\n", 4},
+		{"0,2-5", 6},
+		{"0-7,16-31", 32},
+	}
+
+	for _, test := range tests {
+		n, err := parseCPURange(test.cpus)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if n != test.n {
+			t.Errorf("%q: got %d CPUs, want %d", test.cpus, n, test.n)
+		}
+	}
+
+	if _, err := parseCPURange("x"); err == nil {
+		t.Fatal("Bad CPU list should be rejected.")
+	}
+}
+
+func TestPerCPUMap(t *testing.T) {
+	cpus, err := PossibleCPUs()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 4 byte values are padded to 8 bytes per CPU by the kernel.
+	m, err := NewMap(MapTypePerCpuArray, 4, 4, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[uint32, uint32](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	values := make([]uint32, cpus)
+	for cpu := range values {
+		values[cpu] = uint32(cpu + 1)
+	}
+	if err := tm.PutPerCPU(1, values, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.PutPerCPU(1, values[:0], UpdateAny); err == nil {
+		t.Fatal("Too few values should be rejected.")
+	}
+
+	got, found, err := tm.LookupPerCPU(1)
+	if err != nil || !found {
+		t.Fatal("LookupPerCPU:", found, err)
+	}
+	if len(got) != cpus || got[cpus-1] != uint32(cpus) {
+		t.Fatal("Bad per-CPU values:", got)
+	}
+	if sum := SumPerCPU(got); sum != uint32(cpus*(cpus+1)/2) {
+		t.Fatal("Bad sum:", sum)
+	}
+
+	all, err := tm.AllPerCPU()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || len(all[0]) != cpus || all[1][0] != 1 {
+		t.Fatal("Bad map contents:", all)
+	}
+
+	// The single value accessors would overflow their buffers.
+	if _, _, err := tm.Lookup(1); err == nil {
+		t.Fatal("Lookup on a per-CPU map should be refused.")
+	}
+	var e entry
+	if _, err := m.Lookup(&key{}, &e); err == nil {
+		t.Fatal("Lookup on a per-CPU map should be refused.")
+	}
+
+	// Batch values take a whole per-CPU value each.
+	keys := make([]byte, 2*4)
+	batchValues := make([]byte, 2*8*cpus)
+	var cursor BatchCursor
+	n, err := m.BatchLookup(&cursor, keys, batchValues)
+	if err != nil || n != 2 {
+		t.Fatal("BatchLookup:", n, err)
+	}
+}
+
+func TestMergePerCPU(t *testing.T) {
+	type stats struct {
+		Packets uint64
+		Bytes   uint64
+	}
+
+	total := MergePerCPU([]stats{{1, 100}, {2, 200}}, func(acc stats, v stats) stats {
+		return stats{acc.Packets + v.Packets, acc.Bytes + v.Bytes}
+	})
+	if total != (stats{3, 300}) {
+		t.Fatal("Bad merge:", total)
+	}
+}
@@ -0,0 +1,78 @@
+package bpf
+
+import (
+	"os"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Link is a program attached to a kprobe or tracepoint through a perf event. The program stays attached until
+// Close is called, or until the Link is garbage collected, so keep it around for as long as the program should
+// run.
+type Link struct {
+	fd      int
+	cleanup func() error // Undoes whatever created the event, such as a kprobe added through tracefs.
+}
+
+// Close detaches the program and releases the perf event. Closing an already closed link does nothing.
+func (l *Link) Close() error {
+	if l.fd < 0 {
+		return nil
+	}
+
+	runtime.SetFinalizer(l, nil)
+	unix.IoctlSetInt(l.fd, unix.PERF_EVENT_IOC_DISABLE, 0)
+	err := unix.Close(l.fd)
+	l.fd = -1
+
+	if l.cleanup != nil {
+		if cerr := l.cleanup(); err == nil {
+			err = cerr
+		}
+		l.cleanup = nil
+	}
+
+	return err
+}
+
+// perfEventOpen opens a perf event that a program can be attached to. The event is opened for CPU 0 only, but
+// programs attached to kprobe and tracepoint events run on every CPU.
+func perfEventOpen(attr *unix.PerfEventAttr) (int, error) {
+	attr.Size = uint32(unsafe.Sizeof(*attr))
+	attr.Sample_type = unix.PERF_SAMPLE_RAW
+	attr.Sample = 1
+	attr.Wakeup = 1
+
+	fd, err := unix.PerfEventOpen(attr, -1, 0, -1, unix.PERF_FLAG_FD_CLOEXEC)
+	if err != nil {
+		return -1, os.NewSyscallError("perf_event_open", err)
+	}
+
+	return fd, nil
+}
+
+// perfEventOpenTracepoint opens a perf event for the tracefs event with id.
+func perfEventOpenTracepoint(id uint64) (int, error) {
+	return perfEventOpen(&unix.PerfEventAttr{Type: unix.PERF_TYPE_TRACEPOINT, Config: id})
+}
+
+// attachPerfEvent attaches prog to the perf event fd and enables it. The returned Link owns fd and calls cleanup
+// when closed; on error both are taken care of before returning.
+func attachPerfEvent(fd int, prog *Program, cleanup func() error) (*Link, error) {
+	l := &Link{fd: fd, cleanup: cleanup}
+
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_SET_BPF, prog.fd); err != nil {
+		l.Close()
+		return nil, os.NewSyscallError("ioctl PERF_EVENT_IOC_SET_BPF", err)
+	}
+	if err := unix.IoctlSetInt(fd, unix.PERF_EVENT_IOC_ENABLE, 0); err != nil {
+		l.Close()
+		return nil, os.NewSyscallError("ioctl PERF_EVENT_IOC_ENABLE", err)
+	}
+
+	runtime.SetFinalizer(l, (*Link).Close)
+
+	return l, nil
+}
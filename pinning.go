@@ -0,0 +1,141 @@
+package bpf
+
+import (
+	"crypto/sha1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Values of bpf_elf_map.pinning, see bpf_elf.h.
+const (
+	PinNone     = 0 // The map is private to the loaded object (PIN_NONE).
+	PinObjectNS = 1 // The map is shared by every load of the same object file (PIN_OBJECT_NS).
+	PinGlobalNS = 2 // The map is shared by every object with a map of the same name (PIN_GLOBAL_NS).
+)
+
+// bpfPinPathDefault is where bpffs is mounted on most systems, and where MountBpffs mounts it by default.
+const bpfPinPathDefault = "/sys/fs/bpf"
+
+// bpfPinDirTc is the directory below the bpffs mount point used by tc. Objects loaded by this package and by tc
+// share their pinned maps.
+const bpfPinDirTc = "tc"
+
+// bpfPinDirGlobals holds the PIN_GLOBAL_NS maps, below bpfPinDirTc.
+const bpfPinDirGlobals = "globals"
+
+// bpfMapPinPaths works out where each map is pinned, following the same layout as tc:
+//
+//	<root>/tc/globals/<name>       PIN_GLOBAL_NS
+//	<root>/tc/<sha1 of file>/<name> PIN_OBJECT_NS
+//
+// Maps that aren't pinned get an empty path.
+func bpfMapPinPaths(file string, maps []bpfElfMap, names map[int]string, root string) ([]string, error) {
+	if root == "" {
+		root = bpfDefaultPinPath()
+	}
+
+	paths := make([]string, len(maps))
+	objDir := ""
+
+	for i, m := range maps {
+		switch m.Pinning {
+		case PinNone:
+			continue
+		case PinObjectNS:
+			if objDir == "" {
+				sum, err := bpfObjectHash(file)
+				if err != nil {
+					return nil, err
+				}
+				objDir = filepath.Join(root, bpfPinDirTc, sum)
+			}
+			paths[i] = filepath.Join(objDir, names[i])
+		case PinGlobalNS:
+			paths[i] = filepath.Join(root, bpfPinDirTc, bpfPinDirGlobals, names[i])
+		default:
+			return nil, fmt.Errorf("Map %s has unsupported pinning type %d", names[i], m.Pinning)
+		}
+
+		if names[i] == "" {
+			return nil, fmt.Errorf("Map %d is pinned but has no name", i)
+		}
+	}
+
+	return paths, nil
+}
+
+// bpfObjectHash returns the hex SHA-1 of the object file, which names the PIN_OBJECT_NS directory.
+func bpfObjectHash(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := sha1.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// bpfReusePinnedMap opens the map pinned at path. It returns -1 and no error if nothing is pinned there. The pinned
+// map must have the type, key size, value size and max entries of m.
+func bpfReusePinnedMap(path string, m bpfElfMap, name string) (int, error) {
+	fd, err := BpfObjGet(path)
+	if err != nil {
+		if errors.Is(err, unix.ENOENT) {
+			return -1, nil
+		}
+		return -1, err
+	}
+
+	info, err := bpfPinnedMapInfo(fd, path)
+	if err != nil {
+		unix.Close(fd)
+		return -1, err
+	}
+
+	if info.mapType != m.Type || info.keySize != m.SizeKey || info.valueSize != m.SizeValue ||
+		info.maxEntries != m.MaxElem {
+		unix.Close(fd)
+		return -1, fmt.Errorf("Map %s pinned at %s is type %d with %d byte keys, %d byte values and %d entries "+
+			"but the object defines type %d with %d byte keys, %d byte values and %d entries", name, path,
+			info.mapType, info.keySize, info.valueSize, info.maxEntries, m.Type, m.SizeKey, m.SizeValue, m.MaxElem)
+	}
+
+	return fd, nil
+}
+
+// bpfPinnedMapInfo returns the definition of the map fd opened from path, failing if the object pinned there isn't
+// a map.
+func bpfPinnedMapInfo(fd int, path string) (*bpfMapInfo, error) {
+	kind, err := bpfFDKind(fd)
+	if err != nil {
+		return nil, err
+	}
+	if kind != "bpf-map" {
+		return nil, fmt.Errorf("%s is a %s, not a map", path, kind)
+	}
+
+	return bpfMapGetInfo(fd)
+}
+
+// bpfFDKind returns the kind of bpf object fd refers to, "bpf-map" or "bpf-prog", from the name of its anonymous
+// inode.
+func bpfFDKind(fd int) (string, error) {
+	target, err := os.Readlink(fmt.Sprintf("/proc/self/fd/%d", fd))
+	if err != nil {
+		return "", err
+	}
+
+	return strings.TrimPrefix(target, "anon_inode:"), nil
+}
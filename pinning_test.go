@@ -0,0 +1,280 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+// mountTestBpffs mounts a bpf filesystem on a temporary directory for the duration of the test.
+func mountTestBpffs(t *testing.T) string {
+	t.Helper()
+
+	dir := t.TempDir()
+	if err := MountBpffs(dir); err != nil {
+		t.Skip("Can't mount bpffs:", err)
+	}
+	t.Cleanup(func() {
+		unmountTestBpffs(dir)
+	})
+
+	return dir
+}
+
+func unmountTestBpffs(dir string) {
+	unix.Unmount(dir, unix.MNT_DETACH)
+}
+
+func loadPinnedMaps(t *testing.T, pinPath string) *Collection {
+	t.Helper()
+
+	coll, err := BpfLoadProgWithOptions("bpf/pinned_maps.o", []string{"classifier"}, &LoadOptions{PinPath: pinPath})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return coll
+}
+
+func TestMapPinning(t *testing.T) {
+	pinPath := mountTestBpffs(t)
+
+	coll := loadPinnedMaps(t, pinPath)
+
+	globalPath := filepath.Join(pinPath, "tc", "globals", "pinned_global")
+	if coll.Maps["pinned_global"].PinPath != globalPath {
+		t.Fatal("Bad global pin path:", coll.Maps["pinned_global"].PinPath)
+	}
+	if _, err := os.Stat(globalPath); err != nil {
+		t.Fatal(err)
+	}
+
+	objectHash, err := bpfObjectHash("bpf/pinned_maps.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	objectPath := filepath.Join(pinPath, "tc", objectHash, "pinned_object")
+	if coll.Maps["pinned_object"].PinPath != objectPath {
+		t.Fatal("Bad object pin path:", coll.Maps["pinned_object"].PinPath)
+	}
+
+	global, err := NewTypedMap[uint32, uint64](coll.Maps["pinned_global"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := global.Put(1, 1111, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+	object, err := NewTypedMap[uint32, uint64](coll.Maps["pinned_object"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := object.Put(2, 2222, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+	coll.Close()
+
+	// Loading again reuses the pinned maps, so the values survive.
+	coll = loadPinnedMaps(t, pinPath)
+	defer coll.Close()
+
+	global, _ = NewTypedMap[uint32, uint64](coll.Maps["pinned_global"])
+	value, found, err := global.Lookup(1)
+	if err != nil || !found || value != 1111 {
+		t.Fatal("Global map wasn't reused:", value, found, err)
+	}
+	object, _ = NewTypedMap[uint32, uint64](coll.Maps["pinned_object"])
+	value, found, err = object.Lookup(2)
+	if err != nil || !found || value != 2222 {
+		t.Fatal("Object map wasn't reused:", value, found, err)
+	}
+}
+
+func TestMapPinningMismatch(t *testing.T) {
+	pinPath := mountTestBpffs(t)
+
+	// Pin a map with the right name but too few entries where the object map goes.
+	m, err := NewMap(MapTypeHash, 4, 8, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	objectHash, err := bpfObjectHash("bpf/pinned_maps.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := BpfObjPin(m.FD(), filepath.Join(pinPath, "tc", objectHash, "pinned_object")); err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = BpfLoadProgWithOptions("bpf/pinned_maps.o", []string{"classifier"}, &LoadOptions{PinPath: pinPath})
+	if err == nil {
+		t.Fatal("Mismatching pinned map should be rejected.")
+	}
+
+	// The global map created before the failure must not be left pinned.
+	if _, err := os.Stat(filepath.Join(pinPath, "tc", "globals", "pinned_global")); !os.IsNotExist(err) {
+		t.Fatal("Global map should have been unpinned:", err)
+	}
+}
+
+func TestMapPinningLoadFailure(t *testing.T) {
+	pinPath := mountTestBpffs(t)
+
+	_, err := BpfLoadProgWithOptions("bpf/pinned_maps.o", []string{"classifier", "no_such_section"},
+		&LoadOptions{PinPath: pinPath})
+	if err == nil {
+		t.Fatal("Loading a missing section should fail.")
+	}
+
+	objectHash, err := bpfObjectHash("bpf/pinned_maps.o")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, path := range []string{
+		filepath.Join(pinPath, "tc", "globals", "pinned_global"),
+		filepath.Join(pinPath, "tc", objectHash, "pinned_object"),
+	} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Fatal("Map pinned by the failed load should have been unpinned:", path, err)
+		}
+	}
+
+	// A map that was pinned before the load is left alone.
+	coll := loadPinnedMaps(t, pinPath)
+	coll.Close()
+	if _, err := BpfLoadProgWithOptions("bpf/pinned_maps.o", []string{"classifier", "no_such_section"},
+		&LoadOptions{PinPath: pinPath}); err == nil {
+		t.Fatal("Loading a missing section should fail.")
+	}
+	if _, err := os.Stat(filepath.Join(pinPath, "tc", "globals", "pinned_global")); err != nil {
+		t.Fatal("Map pinned by an earlier load should stay pinned:", err)
+	}
+}
+
+func TestPinMap(t *testing.T) {
+	pinPath := mountTestBpffs(t)
+
+	m, err := NewMap(MapTypeHash, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[uint32, uint64](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Put(1, 42, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+
+	// Parent directories are created.
+	path := filepath.Join(pinPath, "a", "b", "map")
+	if err := m.Pin(path); err != nil {
+		t.Fatal(err)
+	}
+	if m.PinPath != path {
+		t.Fatal("Bad pin path:", m.PinPath)
+	}
+
+	pinned, err := LoadPinnedMap(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pinned.Close()
+
+	if pinned.Type != MapTypeHash || pinned.KeySize != 4 || pinned.ValueSize != 8 || pinned.MaxEntries != 16 ||
+		pinned.PinPath != path {
+		t.Fatal("Bad pinned map definition:", pinned)
+	}
+	tm, err = NewTypedMap[uint32, uint64](pinned)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value, found, err := tm.Lookup(1)
+	if err != nil || !found || value != 42 {
+		t.Fatal("Bad lookup through the pinned map:", value, found, err)
+	}
+
+	if _, err := LoadPinnedProgram(path); err == nil {
+		t.Fatal("Loading a pinned map as a program should fail.")
+	}
+
+	if err := m.Unpin(); err != nil {
+		t.Fatal(err)
+	}
+	if m.PinPath != "" {
+		t.Fatal("Pin path should be cleared.")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Map should have been unpinned:", err)
+	}
+	if _, err := LoadPinnedMap(path); !errors.Is(err, unix.ENOENT) {
+		t.Fatal("Loading an unpinned map should fail with ENOENT:", err)
+	}
+}
+
+func TestPinProgram(t *testing.T) {
+	pinPath := mountTestBpffs(t)
+
+	coll, err := BpfLoadProg("bpf/simple_map.o", []string{"classifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	path := filepath.Join(pinPath, "classifier")
+	if err := coll.Programs["classifier"].Pin(path); err != nil {
+		t.Fatal(err)
+	}
+
+	prog, err := LoadPinnedProgram(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer prog.Close()
+
+	if prog.Name != "cls_main" || prog.Type != ProgTypeSchedCls {
+		t.Fatal("Bad pinned program:", prog)
+	}
+
+	if _, err := LoadPinnedMap(path); err == nil {
+		t.Fatal("Loading a pinned program as a map should fail.")
+	}
+
+	if err := prog.Unpin(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatal("Program should have been unpinned:", err)
+	}
+}
+
+func TestPinOutsideBpffs(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 4, 8, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if err := m.Pin(filepath.Join(t.TempDir(), "map")); err == nil {
+		t.Fatal("Pinning outside bpffs should fail.")
+	}
+
+	// Missing directories aren't created when the pin is refused.
+	dir := t.TempDir()
+	if err := m.Pin(filepath.Join(dir, "tc", "globals", "map")); err == nil {
+		t.Fatal("Pinning outside bpffs should fail.")
+	}
+	if _, err := os.Stat(filepath.Join(dir, "tc")); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Pinning outside bpffs shouldn't create directories:", err)
+	}
+	if err := BpfObjUnpin(filepath.Join(t.TempDir(), "map")); err == nil {
+		t.Fatal("Unpinning outside bpffs should fail.")
+	}
+}
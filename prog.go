@@ -0,0 +1,144 @@
+package bpf
+
+import (
+	"bytes"
+	"fmt"
+	"runtime"
+	"unsafe"
+
+	"golang.org/x/sys/unix"
+)
+
+// Program is an eBPF program that has been loaded into the kernel.
+//
+// A Program owns its file descriptor. Call Close once the program is no longer needed; a finalizer closes the fd
+// if the Program is garbage collected first. Attached programs (tc filters, XDP, ...) stay loaded after Close
+// because the attachment holds its own reference.
+type Program struct {
+	Name    string   // Name of the program's function in the ELF file.
+	Section string   // ELF section the program was loaded from.
+	Type    ProgType // Program type the program was loaded as.
+	PinPath string   // Where the program is pinned, if it is.
+
+	fd int
+}
+
+// setFD hands ownership of fd to the program and arms the finalizer.
+func (p *Program) setFD(fd int) {
+	p.fd = fd
+	runtime.SetFinalizer(p, (*Program).Close)
+}
+
+// FD returns the file descriptor of the program. It returns -1 once the program has been closed.
+func (p *Program) FD() int {
+	return p.fd
+}
+
+// Close releases the file descriptor of the program. Closing an already closed program does nothing.
+func (p *Program) Close() error {
+	if p.fd < 0 {
+		return nil
+	}
+
+	runtime.SetFinalizer(p, nil)
+	err := unix.Close(p.fd)
+	p.fd = -1
+
+	return err
+}
+
+// Clone returns a new Program referring to the same kernel object through a duplicated file descriptor. The
+// clone must be closed independently of the original.
+func (p *Program) Clone() (*Program, error) {
+	fd, err := dupFD(p.fd)
+	if err != nil {
+		return nil, err
+	}
+
+	clone := *p
+	clone.setFD(fd)
+
+	return &clone, nil
+}
+
+// Pin pins the program to pathname on a bpf filesystem, creating missing parent directories, and sets PinPath.
+func (p *Program) Pin(pathname string) error {
+	if err := BpfObjPin(p.fd, pathname); err != nil {
+		return err
+	}
+
+	p.PinPath = pathname
+	return nil
+}
+
+// Unpin removes the pin at PinPath. The program stays usable through its fd. Unpinning a program that isn't
+// pinned does nothing.
+func (p *Program) Unpin() error {
+	if p.PinPath == "" {
+		return nil
+	}
+
+	if err := BpfObjUnpin(p.PinPath); err != nil {
+		return err
+	}
+
+	p.PinPath = ""
+	return nil
+}
+
+// LoadPinnedProgram opens the program pinned at pathname. Name and Type are read from the kernel; Name is the
+// name the program was loaded with, which may be cut short. The Program must be closed by the caller.
+func LoadPinnedProgram(pathname string) (*Program, error) {
+	fd, err := BpfObjGet(pathname)
+	if err != nil {
+		return nil, err
+	}
+
+	kind, err := bpfFDKind(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+	if kind != "bpf-prog" {
+		unix.Close(fd)
+		return nil, fmt.Errorf("%s is a %s, not a program", pathname, kind)
+	}
+
+	info, err := bpfProgGetInfo(fd)
+	if err != nil {
+		unix.Close(fd)
+		return nil, err
+	}
+
+	name := info.name[:]
+	if i := bytes.IndexByte(name, 0); i >= 0 {
+		name = name[:i]
+	}
+
+	p := &Program{
+		Name:    string(name),
+		Type:    ProgType(info.progType),
+		PinPath: pathname,
+	}
+	p.setFD(fd)
+
+	return p, nil
+}
+
+// bpfProgGetInfo asks the kernel about the program fd.
+func bpfProgGetInfo(fd int) (*bpfProgInfo, error) {
+	info := bpfProgInfo{}
+
+	attrs := bpfObjInfoAttr{}
+	attrs.bpfFd = uint32(fd)
+	attrs.infoLen = uint32(unsafe.Sizeof(info))
+	attrs.info = bpfPtr(unsafe.Pointer(&info))
+
+	_, serr := bpfSyscall(bpfCmdObjGetInfoByFd, unsafe.Pointer(&attrs), unsafe.Sizeof(attrs))
+	runtime.KeepAlive(&info)
+	if serr != 0 {
+		return nil, newError(bpfCmdObjGetInfoByFd, serr, fd)
+	}
+
+	return &info, nil
+}
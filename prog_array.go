@@ -0,0 +1,90 @@
+package bpf
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// ProgArray wraps a prog array map, which holds the programs a BPF program can tail call into.
+//
+// Setting a slot replaces the program in it atomically: packets already running the old program finish with it
+// and the next tail call through the slot jumps to the new one, so pipeline stages can be swapped without
+// detaching anything. The array holds its own reference to every program in it, so a Program can be closed once it
+// has been set. The kernel empties the array when the last file descriptor and pin of the map go away, even if
+// programs that use it are still attached; keep the Map open or pinned for as long as the tail calls are needed.
+type ProgArray struct {
+	m *Map
+}
+
+// NewProgArray wraps m, which must be a prog array map.
+func NewProgArray(m *Map) (*ProgArray, error) {
+	if m.Type != MapTypeProgArray {
+		return nil, fmt.Errorf("Map %s isn't a prog array", m.Name)
+	}
+	if m.KeySize != 4 || m.ValueSize != 4 {
+		return nil, fmt.Errorf("Prog array %s has %d byte keys and %d byte values, want 4 and 4", m.Name, m.KeySize,
+			m.ValueSize)
+	}
+
+	return &ProgArray{m: m}, nil
+}
+
+// Map returns the underlying map.
+func (a *ProgArray) Map() *Map {
+	return a.m
+}
+
+// Set puts prog into slot index, replacing the program that was there.
+func (a *ProgArray) Set(index uint32, prog *Program) error {
+	err := fdArraySet(a.m.fd, index, prog.fd)
+	runtime.KeepAlive(a.m)
+	runtime.KeepAlive(prog)
+
+	return withName(err, a.m.Name)
+}
+
+// Delete empties slot index, so tail calls through it fall through to the instruction after the call. It returns
+// false if the slot was already empty.
+func (a *ProgArray) Delete(index uint32) (bool, error) {
+	keyBuf := make([]byte, 4)
+	nativeEndian.PutUint32(keyBuf, index)
+
+	deleted, err := bpfMapDeleteElem(a.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(a.m)
+
+	return deleted, withName(err, a.m.Name)
+}
+
+// ProgID returns the kernel id of the program in slot index. The bool is false if the slot is empty.
+func (a *ProgArray) ProgID(index uint32) (uint32, bool, error) {
+	keyBuf := make([]byte, 4)
+	nativeEndian.PutUint32(keyBuf, index)
+	valueBuf := make([]byte, 4)
+
+	found, err := bpfMapLookupElem(a.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(a.m)
+	if err != nil || !found {
+		return 0, false, withName(err, a.m.Name)
+	}
+
+	return nativeEndian.Uint32(valueBuf), true, nil
+}
+
+// fdArraySet puts valueFd, a program for prog arrays or a cgroup for cgroup arrays, into slot index of the array
+// fd.
+func fdArraySet(fd int, index uint32, valueFd int) error {
+	keyBuf := make([]byte, 4)
+	nativeEndian.PutUint32(keyBuf, index)
+	valueBuf := make([]byte, 4)
+	nativeEndian.PutUint32(valueBuf, uint32(valueFd))
+
+	err := bpfMapUpdateElem(fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])), UpdateAny)
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+
+	return err
+}
@@ -0,0 +1,90 @@
+package bpf
+
+import (
+	"testing"
+)
+
+func TestParseTailCallSection(t *testing.T) {
+	tests := []struct {
+		section string
+		id      uint32
+		key     uint32
+		ok      bool
+	}{
+		{"1/0", 1, 0, true},
+		{"42/7", 42, 7, true},
+		{"classifier", 0, 0, false},
+		{"xdp/drop", 0, 0, false},
+		{"1/", 0, 0, false},
+		{"1/2/3", 0, 0, false},
+	}
+
+	for _, test := range tests {
+		id, key, ok := bpfParseTailCallSection(test.section)
+		if id != test.id || key != test.key || ok != test.ok {
+			t.Errorf("Section %s: got %d %d %v, want %d %d %v", test.section, id, key, ok, test.id, test.key, test.ok)
+		}
+	}
+}
+
+func TestLoadTailCalls(t *testing.T) {
+	// Only the entry point is requested; the tail calls are loaded because they are in the file.
+	coll, err := BpfLoadProg("bpf/tail_call.o", []string{"classifier"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer coll.Close()
+
+	if len(coll.Programs) != 3 {
+		t.Fatal("Wrong number of programs:", len(coll.Programs))
+	}
+	for _, section := range []string{"1/0", "1/1"} {
+		prog := coll.Programs[section]
+		if prog == nil || prog.Type != ProgTypeSchedCls {
+			t.Fatal("Bad tail call program:", section, prog)
+		}
+	}
+
+	jmp, err := NewProgArray(coll.Maps["jmp_map"])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stage0ID, found, err := jmp.ProgID(0)
+	if err != nil || !found {
+		t.Fatal("Slot 0 should hold stage 0:", found, err)
+	}
+	stage1ID, found, err := jmp.ProgID(1)
+	if err != nil || !found || stage1ID == stage0ID {
+		t.Fatal("Slot 1 should hold stage 1:", found, err)
+	}
+
+	// Swap stage 1 into slot 0.
+	if err := jmp.Set(0, coll.Programs["1/1"]); err != nil {
+		t.Fatal(err)
+	}
+	id, found, err := jmp.ProgID(0)
+	if err != nil || !found || id != stage1ID {
+		t.Fatal("Slot 0 should hold stage 1 after the swap:", id, found, err)
+	}
+
+	deleted, err := jmp.Delete(0)
+	if err != nil || !deleted {
+		t.Fatal("Delete:", deleted, err)
+	}
+	if _, found, err := jmp.ProgID(0); err != nil || found {
+		t.Fatal("Slot 0 should be empty:", found, err)
+	}
+}
+
+func TestNewProgArrayChecksMap(t *testing.T) {
+	m, err := NewMap(MapTypeArray, 4, 4, 2, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewProgArray(m); err == nil {
+		t.Fatal("Array map should be rejected.")
+	}
+}
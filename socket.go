@@ -0,0 +1,105 @@
+package bpf
+
+import (
+	"os"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// Socket filters see every packet queued to the socket they are attached to. The program returns how many bytes
+// of the packet to keep, so returning 0 drops it. Programs attached to a reuseport group instead return the index
+// of the socket in the group, in bind order, that gets the packet; an index out of range falls back to the hash.
+//
+// The Conn variants take anything with a SyscallConn method: *net.TCPConn, *net.UDPConn, *net.UnixConn,
+// *net.IPConn, their listeners and *os.File. A net.Conn from net.Dial can be converted with conn.(syscall.Conn).
+
+// AttachSocketFilter attaches prog, which must be a ProgTypeSocketFilter program, to the socket, replacing any
+// filter already attached.
+func AttachSocketFilter(fd int, prog *Program) error {
+	return socketSetsockopt(fd, unix.SO_ATTACH_BPF, prog.fd)
+}
+
+// DetachSocketFilter detaches the filter attached to the socket. The error matches unix.ENOENT if there is none.
+func DetachSocketFilter(fd int) error {
+	return socketSetsockopt(fd, unix.SO_DETACH_BPF, 0)
+}
+
+// AttachReuseportProg attaches prog, which must be a ProgTypeSocketFilter program, to the reuseport group of the
+// socket. The socket must have SO_REUSEPORT set and be bound.
+func AttachReuseportProg(fd int, prog *Program) error {
+	return socketSetsockopt(fd, unix.SO_ATTACH_REUSEPORT_EBPF, prog.fd)
+}
+
+// DetachReuseportProg detaches the program attached to the reuseport group of the socket. It needs Linux 5.3.
+func DetachReuseportProg(fd int) error {
+	return socketSetsockopt(fd, unix.SO_DETACH_REUSEPORT_BPF, 0)
+}
+
+// AttachSocketFilterRawConn is AttachSocketFilter for a syscall.RawConn.
+func AttachSocketFilterRawConn(conn syscall.RawConn, prog *Program) error {
+	return rawConnSetsockopt(conn, unix.SO_ATTACH_BPF, prog.fd)
+}
+
+// DetachSocketFilterRawConn is DetachSocketFilter for a syscall.RawConn.
+func DetachSocketFilterRawConn(conn syscall.RawConn) error {
+	return rawConnSetsockopt(conn, unix.SO_DETACH_BPF, 0)
+}
+
+// AttachReuseportProgRawConn is AttachReuseportProg for a syscall.RawConn.
+func AttachReuseportProgRawConn(conn syscall.RawConn, prog *Program) error {
+	return rawConnSetsockopt(conn, unix.SO_ATTACH_REUSEPORT_EBPF, prog.fd)
+}
+
+// DetachReuseportProgRawConn is DetachReuseportProg for a syscall.RawConn.
+func DetachReuseportProgRawConn(conn syscall.RawConn) error {
+	return rawConnSetsockopt(conn, unix.SO_DETACH_REUSEPORT_BPF, 0)
+}
+
+// AttachSocketFilterConn is AttachSocketFilter for a connection or listener.
+func AttachSocketFilterConn(conn syscall.Conn, prog *Program) error {
+	return connSetsockopt(conn, unix.SO_ATTACH_BPF, prog.fd)
+}
+
+// DetachSocketFilterConn is DetachSocketFilter for a connection or listener.
+func DetachSocketFilterConn(conn syscall.Conn) error {
+	return connSetsockopt(conn, unix.SO_DETACH_BPF, 0)
+}
+
+// AttachReuseportProgConn is AttachReuseportProg for a connection or listener.
+func AttachReuseportProgConn(conn syscall.Conn, prog *Program) error {
+	return connSetsockopt(conn, unix.SO_ATTACH_REUSEPORT_EBPF, prog.fd)
+}
+
+// DetachReuseportProgConn is DetachReuseportProg for a connection or listener.
+func DetachReuseportProgConn(conn syscall.Conn) error {
+	return connSetsockopt(conn, unix.SO_DETACH_REUSEPORT_BPF, 0)
+}
+
+func socketSetsockopt(fd int, opt int, value int) error {
+	if err := unix.SetsockoptInt(fd, unix.SOL_SOCKET, opt, value); err != nil {
+		return os.NewSyscallError("setsockopt", err)
+	}
+
+	return nil
+}
+
+func rawConnSetsockopt(conn syscall.RawConn, opt int, value int) error {
+	var err error
+	if cerr := conn.Control(func(fd uintptr) {
+		err = socketSetsockopt(int(fd), opt, value)
+	}); cerr != nil {
+		return cerr
+	}
+
+	return err
+}
+
+func connSetsockopt(conn syscall.Conn, opt int, value int) error {
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		return err
+	}
+
+	return rawConnSetsockopt(raw, opt, value)
+}
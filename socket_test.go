@@ -0,0 +1,111 @@
+package bpf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"syscall"
+	"testing"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// udpReceives reports whether conn receives a datagram sent to it.
+func udpReceives(t *testing.T, conn *net.UDPConn) bool {
+	t.Helper()
+
+	sender, err := net.DialUDP("udp4", nil, conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sender.Close()
+
+	if _, err := sender.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	_, err = conn.Read(make([]byte, 16))
+
+	return err == nil
+}
+
+func TestSocketFilter(t *testing.T) {
+	conn, err := net.ListenUDP("udp4", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	drop := loadTestProg(t, "drop", ProgTypeSocketFilter, 0)
+	defer drop.Close()
+
+	if err := AttachSocketFilterConn(conn, drop); err != nil {
+		t.Fatal(err)
+	}
+	if udpReceives(t, conn) {
+		t.Fatal("Filter should drop every packet.")
+	}
+
+	raw, err := conn.SyscallConn()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := DetachSocketFilterRawConn(raw); err != nil {
+		t.Fatal(err)
+	}
+	if !udpReceives(t, conn) {
+		t.Fatal("Packets should be received once the filter is detached.")
+	}
+
+	if err := DetachSocketFilterRawConn(raw); !errors.Is(err, unix.ENOENT) {
+		t.Fatal("Detaching without a filter should fail with ENOENT:", err)
+	}
+
+	// Only socket filters can be attached.
+	xdp := loadTestProg(t, "xdp_pass", ProgTypeXdp, 2)
+	defer xdp.Close()
+
+	if err := AttachSocketFilterConn(conn, xdp); !errors.Is(err, unix.EINVAL) {
+		t.Fatal("Attaching an XDP program should fail with EINVAL:", err)
+	}
+}
+
+func TestReuseportProg(t *testing.T) {
+	lc := net.ListenConfig{
+		Control: func(network, address string, c syscall.RawConn) error {
+			return rawConnSetsockopt(c, unix.SO_REUSEPORT, 1)
+		},
+	}
+
+	first, err := lc.ListenPacket(context.Background(), "udp4", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer first.Close()
+
+	second, err := lc.ListenPacket(context.Background(), "udp4", first.LocalAddr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer second.Close()
+
+	// Every packet goes to the second socket of the group.
+	prog := loadTestProg(t, "reuseport_second", ProgTypeSocketFilter, 1)
+	defer prog.Close()
+
+	if err := AttachReuseportProgConn(first.(*net.UDPConn), prog); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 8; i++ {
+		if !udpReceives(t, second.(*net.UDPConn)) {
+			t.Fatal("Packet should have been steered to the second socket.")
+		}
+	}
+
+	if err := DetachReuseportProgConn(second.(*net.UDPConn)); err != nil {
+		t.Fatal(err)
+	}
+}
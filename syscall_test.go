@@ -0,0 +1,17 @@
+package bpf
+
+import (
+	"testing"
+	"unsafe"
+)
+
+func TestNativeEndian(t *testing.T) {
+	var v uint16 = 0x0102
+	b := (*[2]byte)(unsafe.Pointer(&v))
+
+	var buf [2]byte
+	nativeEndian.PutUint16(buf[:], v)
+	if buf != *b {
+		t.Fatalf("nativeEndian is %s but the host stores 0x0102 as %v", nativeEndian, *b)
+	}
+}
@@ -0,0 +1,263 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+
+	"golang.org/x/sys/unix"
+)
+
+// TcDirection selects the clsact hook a cls_bpf filter is attached to.
+type TcDirection int
+
+const (
+	TcIngress TcDirection = iota // Packets received by the interface.
+	TcEgress                     // Packets sent by the interface.
+)
+
+func (d TcDirection) String() string {
+	switch d {
+	case TcIngress:
+		return "ingress"
+	case TcEgress:
+		return "egress"
+	}
+
+	return fmt.Sprintf("TcDirection(%d)", int(d))
+}
+
+// Handles and attributes from include/uapi/linux/pkt_sched.h and pkt_cls.h.
+const (
+	tcHandleClsact  = 0xffff0000 // ffff:, the handle tc gives the clsact qdisc.
+	tcParentClsact  = 0xfffffff1 // TC_H_CLSACT
+	tcParentIngress = 0xfffffff2 // TC_H_MAKE(TC_H_CLSACT, TC_H_MIN_INGRESS)
+	tcParentEgress  = 0xfffffff3 // TC_H_MAKE(TC_H_CLSACT, TC_H_MIN_EGRESS)
+
+	tcaBpfFD    = 6
+	tcaBpfName  = 7
+	tcaBpfFlags = 8
+	tcaBpfID    = 11
+
+	tcaBpfFlagActDirect = 1
+
+	ethPAll = 0x0003
+)
+
+// sizeofTcMsg is the size of struct tcmsg.
+const sizeofTcMsg = 20
+
+// TcFilter identifies a cls_bpf filter on the clsact qdisc of an interface. Filters are always attached in
+// direct-action mode, so the program's return value is the tc action (TC_ACT_OK, TC_ACT_SHOT, ...).
+type TcFilter struct {
+	Ifindex   int
+	Direction TcDirection
+	Priority  uint16 // Zero lets the kernel pick one when attaching, which TcAttachFilter writes back.
+	Handle    uint32 // Zero lets the kernel pick one when attaching, which TcAttachFilter writes back.
+	Name      string // Shown by tc filter show. Empty uses the program's name.
+	ProgID    uint32 // Kernel id of the attached program. Set by TcListFilters, TcAttachFilter and TcReplaceFilter.
+}
+
+func (f *TcFilter) parent() (uint32, error) {
+	switch f.Direction {
+	case TcIngress:
+		return tcParentIngress, nil
+	case TcEgress:
+		return tcParentEgress, nil
+	}
+
+	return 0, fmt.Errorf("Bad tc direction %d", f.Direction)
+}
+
+// TcAddClsact adds the clsact qdisc, which the ingress and egress filters hang off, to the interface. It does
+// nothing if the interface already has one.
+func TcAddClsact(ifindex int) error {
+	attrs := netlinkAttrs{}
+	attrs.addString(unix.TCA_KIND, "clsact")
+
+	err := tcRequest("add clsact qdisc", unix.RTM_NEWQDISC, unix.NLM_F_CREATE|unix.NLM_F_EXCL,
+		tcMsg(ifindex, tcHandleClsact, tcParentClsact, 0), &attrs)
+	if errors.Is(err, unix.EEXIST) {
+		return nil
+	}
+
+	return err
+}
+
+// TcDelClsact removes the clsact qdisc from the interface, together with every filter attached to it.
+func TcDelClsact(ifindex int) error {
+	attrs := netlinkAttrs{}
+	attrs.addString(unix.TCA_KIND, "clsact")
+
+	return tcRequest("delete clsact qdisc", unix.RTM_DELQDISC, 0, tcMsg(ifindex, tcHandleClsact, tcParentClsact, 0),
+		&attrs)
+}
+
+// TcAttachFilter attaches prog, which must be a ProgTypeSchedCls program, as a new filter. The interface must
+// have a clsact qdisc (see TcAddClsact). If a filter with the same priority and handle exists, the error matches
+// unix.EEXIST. The priority, handle and program id the filter ended up with are written back into filter.
+func TcAttachFilter(filter *TcFilter, prog *Program) error {
+	return tcNewFilter("add filter", filter, prog, unix.NLM_F_CREATE|unix.NLM_F_EXCL)
+}
+
+// TcReplaceFilter is like TcAttachFilter but atomically replaces the program of an existing filter with the same
+// priority and handle, which must both be set.
+func TcReplaceFilter(filter *TcFilter, prog *Program) error {
+	if filter.Priority == 0 || filter.Handle == 0 {
+		return errors.New("Replacing a filter needs its priority and handle")
+	}
+
+	return tcNewFilter("replace filter", filter, prog, unix.NLM_F_CREATE)
+}
+
+// TcDeleteFilter removes the filter with the priority and handle of filter, which must both be set.
+func TcDeleteFilter(filter *TcFilter) error {
+	if filter.Priority == 0 || filter.Handle == 0 {
+		return errors.New("Deleting a filter needs its priority and handle")
+	}
+
+	parent, err := filter.parent()
+	if err != nil {
+		return err
+	}
+
+	attrs := netlinkAttrs{}
+	attrs.addString(unix.TCA_KIND, "bpf")
+
+	return tcRequest("delete filter", unix.RTM_DELTFILTER, 0,
+		tcMsg(filter.Ifindex, filter.Handle, parent, tcFilterInfo(filter.Priority)), &attrs)
+}
+
+// TcListFilters returns the cls_bpf filters attached in direction to the interface.
+func TcListFilters(ifindex int, direction TcDirection) ([]TcFilter, error) {
+	parent, err := (&TcFilter{Direction: direction}).parent()
+	if err != nil {
+		return nil, err
+	}
+
+	conn, err := dialNetlink()
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	replies, err := conn.request("list filters", unix.RTM_GETTFILTER, unix.NLM_F_DUMP, tcMsg(ifindex, 0, parent, 0))
+	if err != nil {
+		return nil, err
+	}
+
+	var filters []TcFilter
+	for _, reply := range replies {
+		// Each priority also reports itself with handle 0 and no options.
+		if filter, ok := tcParseFilter(reply, ifindex, direction); ok && filter.Handle != 0 {
+			filters = append(filters, filter)
+		}
+	}
+
+	return filters, nil
+}
+
+func tcNewFilter(op string, filter *TcFilter, prog *Program, flags uint16) error {
+	parent, err := filter.parent()
+	if err != nil {
+		return err
+	}
+
+	name := filter.Name
+	if name == "" {
+		name = prog.Name
+	}
+
+	options := netlinkAttrs{}
+	options.addU32(tcaBpfFD, uint32(prog.fd))
+	options.addString(tcaBpfName, name)
+	options.addU32(tcaBpfFlags, tcaBpfFlagActDirect)
+
+	attrs := netlinkAttrs{}
+	attrs.addString(unix.TCA_KIND, "bpf")
+	attrs.addNested(unix.TCA_OPTIONS, &options)
+
+	conn, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	// NLM_F_ECHO has the kernel send the new filter back, with the priority and handle it picked.
+	msg := tcMsg(filter.Ifindex, filter.Handle, parent, tcFilterInfo(filter.Priority))
+	replies, err := conn.request(op, unix.RTM_NEWTFILTER, flags|unix.NLM_F_ECHO, append(msg, attrs.buf...))
+	if err != nil {
+		return err
+	}
+
+	for _, reply := range replies {
+		if echo, ok := tcParseFilter(reply, filter.Ifindex, filter.Direction); ok && echo.Handle != 0 {
+			filter.Priority = echo.Priority
+			filter.Handle = echo.Handle
+			filter.ProgID = echo.ProgID
+			break
+		}
+	}
+
+	return nil
+}
+
+// tcParseFilter decodes a RTM_NEWTFILTER message about a cls_bpf filter.
+func tcParseFilter(reply []byte, ifindex int, direction TcDirection) (TcFilter, bool) {
+	if len(reply) < sizeofTcMsg {
+		return TcFilter{}, false
+	}
+
+	handle := nativeEndian.Uint32(reply[8:])
+	info := nativeEndian.Uint32(reply[16:])
+	attrs := parseNetlinkAttrs(reply[sizeofTcMsg:])
+	if netlinkString(attrs[unix.TCA_KIND]) != "bpf" {
+		return TcFilter{}, false
+	}
+
+	filter := TcFilter{
+		Ifindex:   ifindex,
+		Direction: direction,
+		Priority:  uint16(info >> 16),
+		Handle:    handle,
+	}
+
+	options := parseNetlinkAttrs(attrs[unix.TCA_OPTIONS])
+	filter.Name = netlinkString(options[tcaBpfName])
+	if id := options[tcaBpfID]; len(id) == 4 {
+		filter.ProgID = nativeEndian.Uint32(id)
+	}
+
+	return filter, true
+}
+
+// tcRequest sends a tc request made up of a struct tcmsg and attributes on a new netlink socket.
+func tcRequest(op string, msgType uint16, flags uint16, msg []byte, attrs *netlinkAttrs) error {
+	conn, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.request(op, msgType, flags, append(msg, attrs.buf...))
+	return err
+}
+
+// tcMsg encodes a struct tcmsg.
+func tcMsg(ifindex int, handle uint32, parent uint32, info uint32) []byte {
+	msg := make([]byte, sizeofTcMsg)
+	msg[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(msg[4:], uint32(int32(ifindex)))
+	nativeEndian.PutUint32(msg[8:], handle)
+	nativeEndian.PutUint32(msg[12:], parent)
+	nativeEndian.PutUint32(msg[16:], info)
+
+	return msg
+}
+
+// tcFilterInfo builds tcm_info for a filter matching every protocol: the priority in the upper half and the
+// protocol, in network byte order, in the lower half.
+func tcFilterInfo(priority uint16) uint32 {
+	protocol := []byte{ethPAll >> 8, ethPAll & 0xff}
+
+	return uint32(priority)<<16 | uint32(nativeEndian.Uint16(protocol))
+}
@@ -0,0 +1,127 @@
+package bpf
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestTcFilterInfo(t *testing.T) {
+	info := tcFilterInfo(3)
+	if info>>16 != 3 {
+		t.Fatal("Bad priority:", info>>16)
+	}
+
+	// The protocol is ETH_P_ALL in network byte order.
+	protocol := make([]byte, 2)
+	nativeEndian.PutUint16(protocol, uint16(info))
+	if protocol[0] != 0x00 || protocol[1] != 0x03 {
+		t.Fatalf("Bad protocol: %x", protocol)
+	}
+}
+
+func TestTcFilters(t *testing.T) {
+	ifindex := enterTestNetns(t)
+
+	prog := loadTestProg(t, "classifier", ProgTypeSchedCls, 0)
+	defer prog.Close()
+
+	if err := TcAddClsact(ifindex); err != nil {
+		t.Fatal(err)
+	}
+	// Adding it twice is fine.
+	if err := TcAddClsact(ifindex); err != nil {
+		t.Fatal(err)
+	}
+
+	ingress := &TcFilter{Ifindex: ifindex, Direction: TcIngress, Priority: 1, Handle: 1, Name: "ingress_filter"}
+	if err := TcAttachFilter(ingress, prog); err != nil {
+		t.Fatal(err)
+	}
+	egress := &TcFilter{Ifindex: ifindex, Direction: TcEgress, Priority: 2, Handle: 5}
+	if err := TcAttachFilter(egress, prog); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TcAttachFilter(ingress, prog); !errors.Is(err, unix.EEXIST) {
+		t.Fatal("Attaching the same filter twice should fail with EEXIST:", err)
+	}
+
+	filters, err := TcListFilters(ifindex, TcIngress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 {
+		t.Fatal("Wrong number of ingress filters:", filters)
+	}
+	f := filters[0]
+	if f.Priority != 1 || f.Handle != 1 || f.Name != "ingress_filter" || f.ProgID == 0 || f.Direction != TcIngress {
+		t.Fatal("Bad ingress filter:", f)
+	}
+	progID := f.ProgID
+
+	filters, err = TcListFilters(ifindex, TcEgress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 || filters[0].Priority != 2 || filters[0].Handle != 5 {
+		t.Fatal("Bad egress filters:", filters)
+	}
+
+	// Replace the ingress program with a fresh copy.
+	prog2 := loadTestProg(t, "classifier", ProgTypeSchedCls, 0)
+	defer prog2.Close()
+
+	if err := TcReplaceFilter(ingress, prog2); err != nil {
+		t.Fatal(err)
+	}
+	filters, err = TcListFilters(ifindex, TcIngress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 1 || filters[0].ProgID == progID {
+		t.Fatal("Program should have been replaced:", filters)
+	}
+
+	if err := TcDeleteFilter(ingress); err != nil {
+		t.Fatal(err)
+	}
+	filters, err = TcListFilters(ifindex, TcIngress)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(filters) != 0 {
+		t.Fatal("Ingress filter should have been deleted:", filters)
+	}
+
+	// The kernel picks the priority and handle of a filter attached without them, and they are written back.
+	auto := &TcFilter{Ifindex: ifindex, Direction: TcIngress}
+	if err := TcAttachFilter(auto, prog); err != nil {
+		t.Fatal(err)
+	}
+	if auto.Priority == 0 || auto.Handle == 0 || auto.ProgID != progID {
+		t.Fatal("Kernel picked priority and handle should be written back:", auto)
+	}
+	filters, err = TcListFilters(ifindex, TcIngress)
+	if err != nil || len(filters) != 1 || filters[0].Priority != auto.Priority || filters[0].Handle != auto.Handle {
+		t.Fatal("Listed filter doesn't match the written back one:", filters, err)
+	}
+	if err := TcReplaceFilter(auto, prog2); err != nil {
+		t.Fatal(err)
+	}
+	if auto.ProgID == progID {
+		t.Fatal("Replacing should write back the new program id:", auto)
+	}
+	if err := TcDeleteFilter(auto); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := TcDelClsact(ifindex); err != nil {
+		t.Fatal(err)
+	}
+	filters, err = TcListFilters(ifindex, TcEgress)
+	if err != nil || len(filters) != 0 {
+		t.Fatal("Removing clsact should remove the egress filter:", filters, err)
+	}
+}
@@ -0,0 +1,87 @@
+package bpf
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// ErrNoTracefs is returned when no tracefs is mounted, so tracepoints and tracefs kprobes can't be used.
+var ErrNoTracefs = errors.New("no tracefs is mounted")
+
+// tracefsPaths are where tracefs usually is. Looking at the debugfs one mounts tracefs automatically.
+var tracefsPaths = []string{"/sys/kernel/tracing", "/sys/kernel/debug/tracing"}
+
+// tracefsPath returns where tracefs is mounted, or ErrNoTracefs.
+func tracefsPath() (string, error) {
+	for _, path := range tracefsPaths {
+		var st unix.Statfs_t
+		if err := unix.Statfs(path, &st); err == nil && uint32(st.Type) == uint32(unix.TRACEFS_MAGIC) {
+			return path, nil
+		}
+	}
+
+	f, err := os.Open(mountinfoFile)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	mounts, err := parseMountinfo(f)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range mounts {
+		if m.fsType == "tracefs" {
+			return m.mountpoint, nil
+		}
+	}
+
+	return "", ErrNoTracefs
+}
+
+// tracefsEventID returns the id of the tracefs event group/name, which perf_event_open takes as the config of a
+// PERF_TYPE_TRACEPOINT event.
+func tracefsEventID(group string, name string) (uint64, error) {
+	if !tracefsValidName(group) || !tracefsValidName(name) {
+		return 0, fmt.Errorf("Bad trace event name %s/%s", group, name)
+	}
+
+	root, err := tracefsPath()
+	if err != nil {
+		return 0, err
+	}
+
+	data, err := os.ReadFile(filepath.Join(root, "events", group, name, "id"))
+	if err != nil {
+		return 0, err
+	}
+
+	id, err := strconv.ParseUint(strings.TrimSpace(string(data)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("Bad id for trace event %s/%s: %w", group, name, err)
+	}
+
+	return id, nil
+}
+
+// tracefsValidName reports whether s can be used as an event or group name without escaping the events
+// directory.
+func tracefsValidName(s string) bool {
+	if s == "" {
+		return false
+	}
+
+	for _, c := range s {
+		if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || c == '_') {
+			return false
+		}
+	}
+
+	return true
+}
@@ -0,0 +1,265 @@
+package bpf
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// AttachTracepoint attaches prog, which must be a ProgTypeTracepoint program, to the tracepoint group/name, such
+// as sched/sched_switch. The program gets a pointer to the record described by ReadTracepointFormat.
+func AttachTracepoint(group string, name string, prog *Program) (*Link, error) {
+	id, err := tracefsEventID(group, name)
+	if err != nil {
+		return nil, err
+	}
+
+	fd, err := perfEventOpenTracepoint(id)
+	if err != nil {
+		return nil, fmt.Errorf("tracepoint %s/%s: %w", group, name, err)
+	}
+
+	return attachPerfEvent(fd, prog, nil)
+}
+
+// TracepointFormat is the layout of the records of a tracepoint.
+type TracepointFormat struct {
+	Name   string
+	ID     uint64
+	Fields []TracepointField // In record order, starting with the common fields.
+}
+
+// TracepointField is a field of a tracepoint record.
+type TracepointField struct {
+	Name     string // Field name, such as "prev_pid".
+	Type     string // C type without the name or array length, such as "pid_t" or "const char *".
+	ArrayLen int    // Number of elements of a fixed size array, 0 if the field isn't one.
+	Offset   int    // Byte offset of the field in the record.
+	Size     int    // Size of the field in bytes.
+	Signed   bool
+	Common   bool // Whether the field is in every record (common_type, common_pid, ...).
+	DataLoc  bool // Whether the field is a __data_loc: the offset and length of dynamic data within the record.
+	RelLoc   bool // Like DataLoc, but the offset is from the end of the field (__rel_loc).
+}
+
+// Field returns the field called name.
+func (f *TracepointFormat) Field(name string) (*TracepointField, bool) {
+	for i := range f.Fields {
+		if f.Fields[i].Name == name {
+			return &f.Fields[i], true
+		}
+	}
+
+	return nil, false
+}
+
+// ReadTracepointFormat reads the format of the tracepoint group/name from tracefs.
+func ReadTracepointFormat(group string, name string) (*TracepointFormat, error) {
+	if !tracefsValidName(group) || !tracefsValidName(name) {
+		return nil, fmt.Errorf("Bad trace event name %s/%s", group, name)
+	}
+
+	root, err := tracefsPath()
+	if err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(root, "events", group, name, "format"))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseTracepointFormat(f)
+}
+
+// ParseTracepointFormat parses a tracefs format file. The print fmt line is ignored.
+func ParseTracepointFormat(r io.Reader) (*TracepointFormat, error) {
+	format := &TracepointFormat{}
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+
+		switch {
+		case strings.HasPrefix(line, "name:"):
+			format.Name = strings.TrimSpace(strings.TrimPrefix(line, "name:"))
+		case strings.HasPrefix(line, "ID:"):
+			id, err := strconv.ParseUint(strings.TrimSpace(strings.TrimPrefix(line, "ID:")), 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("Bad tracepoint ID line %q", line)
+			}
+			format.ID = id
+		case strings.HasPrefix(line, "field:"):
+			field, err := parseTracepointField(line)
+			if err != nil {
+				return nil, err
+			}
+			format.Fields = append(format.Fields, field)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	if format.Name == "" || len(format.Fields) == 0 {
+		return nil, errors.New("Bad tracepoint format: no name or fields")
+	}
+
+	return format, nil
+}
+
+// parseTracepointField parses a line like
+// "field:char prev_comm[16];	offset:8;	size:16;	signed:0;".
+func parseTracepointField(line string) (TracepointField, error) {
+	field := TracepointField{}
+	bad := fmt.Errorf("Bad tracepoint field %q", line)
+
+	var haveOffset, haveSize bool
+	for _, part := range strings.Split(line, ";") {
+		key, value, ok := strings.Cut(strings.TrimSpace(part), ":")
+		if !ok {
+			continue
+		}
+
+		var err error
+		switch key {
+		case "field":
+			err = parseTracepointDecl(value, &field)
+		case "offset":
+			field.Offset, err = strconv.Atoi(value)
+			haveOffset = true
+		case "size":
+			field.Size, err = strconv.Atoi(value)
+			haveSize = true
+		case "signed":
+			field.Signed = value == "1"
+		}
+		if err != nil {
+			return field, bad
+		}
+	}
+
+	if field.Name == "" || !haveOffset || !haveSize || field.Offset < 0 || field.Size < 0 {
+		return field, bad
+	}
+
+	return field, nil
+}
+
+// parseTracepointDecl parses the C declaration of a field, such as "const char * filename", "char comm[16]" or
+// "__data_loc char[] name".
+func parseTracepointDecl(decl string, field *TracepointField) error {
+	decl = strings.TrimSpace(decl)
+
+	if strings.HasSuffix(decl, "]") {
+		open := strings.LastIndexByte(decl, '[')
+		if open < 0 {
+			return errors.New("unbalanced brackets")
+		}
+		n, err := strconv.Atoi(decl[open+1 : len(decl)-1])
+		if err != nil {
+			return err
+		}
+		field.ArrayLen = n
+		decl = strings.TrimSpace(decl[:open])
+	}
+
+	split := strings.LastIndexAny(decl, " *")
+	if split < 0 || split == len(decl)-1 {
+		return errors.New("no field name")
+	}
+	field.Name = decl[split+1:]
+	field.Type = strings.TrimSpace(decl[:split+1])
+	field.Common = strings.HasPrefix(field.Name, "common_")
+
+	if typ, ok := strings.CutPrefix(field.Type, "__data_loc "); ok {
+		field.Type = typ
+		field.DataLoc = true
+	} else if typ, ok := strings.CutPrefix(field.Type, "__rel_loc "); ok {
+		field.Type = typ
+		field.RelLoc = true
+	}
+
+	return nil
+}
+
+// Decode returns the value of the field in record: an int64 or uint64 for integers of 1, 2, 4 or 8 bytes, a
+// string for char arrays and dynamic char[] data, and the raw bytes otherwise. Records are in host byte order.
+func (f *TracepointField) Decode(record []byte) (interface{}, error) {
+	if f.Offset+f.Size > len(record) {
+		return nil, fmt.Errorf("Record of %d bytes is too short for field %s", len(record), f.Name)
+	}
+	data := record[f.Offset : f.Offset+f.Size]
+
+	if f.DataLoc || f.RelLoc {
+		if f.Size != 4 {
+			return nil, fmt.Errorf("Bad size %d for dynamic field %s", f.Size, f.Name)
+		}
+
+		loc := nativeEndian.Uint32(data)
+		start, length := int(loc&0xffff), int(loc>>16)
+		if f.RelLoc {
+			start += f.Offset + f.Size
+		}
+		if start+length > len(record) {
+			return nil, fmt.Errorf("Dynamic field %s is out of the record", f.Name)
+		}
+		data = record[start : start+length]
+
+		if f.Type == "char[]" {
+			return tracepointString(data), nil
+		}
+		return data, nil
+	}
+
+	if f.ArrayLen > 0 {
+		if f.Type == "char" {
+			return tracepointString(data), nil
+		}
+		return data, nil
+	}
+
+	var v uint64
+	switch f.Size {
+	case 1:
+		v = uint64(data[0])
+		if f.Signed {
+			return int64(int8(v)), nil
+		}
+	case 2:
+		v = uint64(nativeEndian.Uint16(data))
+		if f.Signed {
+			return int64(int16(v)), nil
+		}
+	case 4:
+		v = uint64(nativeEndian.Uint32(data))
+		if f.Signed {
+			return int64(int32(v)), nil
+		}
+	case 8:
+		v = nativeEndian.Uint64(data)
+		if f.Signed {
+			return int64(v), nil
+		}
+	default:
+		return data, nil
+	}
+
+	return v, nil
+}
+
+// tracepointString decodes a NUL padded char array.
+func tracepointString(b []byte) string {
+	if i := bytes.IndexByte(b, 0); i >= 0 {
+		b = b[:i]
+	}
+
+	return string(b)
+}
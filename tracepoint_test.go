@@ -0,0 +1,159 @@
+package bpf
+
+import (
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testTracepointFormat = `name: sched_process_exec
+ID: 318
+format:
+	field:unsigned short common_type;	offset:0;	size:2;	signed:0;
+	field:unsigned char common_flags;	offset:2;	size:1;	signed:0;
+	field:unsigned char common_preempt_count;	offset:3;	size:1;	signed:0;
+	field:int common_pid;	offset:4;	size:4;	signed:1;
+
+	field:__data_loc char[] filename;	offset:8;	size:4;	signed:0;
+	field:pid_t pid;	offset:12;	size:4;	signed:1;
+	field:char comm[8];	offset:16;	size:8;	signed:0;
+	field:const char * ptr;	offset:24;	size:8;	signed:0;
+
+print fmt: "filename=%s pid=%d", __get_str(filename), REC->pid
+`
+
+func TestParseTracepointFormat(t *testing.T) {
+	format, err := ParseTracepointFormat(strings.NewReader(testTracepointFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if format.Name != "sched_process_exec" || format.ID != 318 || len(format.Fields) != 8 {
+		t.Fatal("Bad format:", format)
+	}
+
+	want := []TracepointField{
+		{Name: "common_type", Type: "unsigned short", Offset: 0, Size: 2, Common: true},
+		{Name: "common_pid", Type: "int", Offset: 4, Size: 4, Signed: true, Common: true},
+		{Name: "filename", Type: "char[]", Offset: 8, Size: 4, DataLoc: true},
+		{Name: "pid", Type: "pid_t", Offset: 12, Size: 4, Signed: true},
+		{Name: "comm", Type: "char", ArrayLen: 8, Offset: 16, Size: 8},
+		{Name: "ptr", Type: "const char *", Offset: 24, Size: 8},
+	}
+	for _, w := range want {
+		field, ok := format.Field(w.Name)
+		if !ok {
+			t.Fatal("Missing field", w.Name)
+		}
+		if *field != w {
+			t.Errorf("Field %s: got %+v, want %+v", w.Name, *field, w)
+		}
+	}
+
+	for _, bad := range []string{
+		"",
+		"name: x\nID: 1\n",
+		"name: x\n\tfield:int a;\tsize:4;\n",
+		"name: x\n\tfield:int a[z];\toffset:0;\tsize:4;\n",
+		"name: x\n\tfield:int;\toffset:0;\tsize:4;\n",
+	} {
+		if _, err := ParseTracepointFormat(strings.NewReader(bad)); err == nil {
+			t.Errorf("Format %q should be rejected.", bad)
+		}
+	}
+}
+
+func TestTracepointFieldDecode(t *testing.T) {
+	format, err := ParseTracepointFormat(strings.NewReader(testTracepointFormat))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	record := make([]byte, 40)
+	nativeEndian.PutUint16(record[0:], 318)
+	nativeEndian.PutUint32(record[4:], 1234)
+	nativeEndian.PutUint32(record[8:], 6<<16|32) // filename: 6 bytes at 32
+	nativeEndian.PutUint32(record[12:], uint32(0xffffffff))
+	copy(record[16:], "bash")
+	copy(record[32:], "/bin/\x00")
+
+	want := map[string]interface{}{
+		"common_type": uint64(318),
+		"common_pid":  int64(1234),
+		"filename":    "/bin/",
+		"pid":         int64(-1),
+		"comm":        "bash",
+		"ptr":         uint64(0),
+	}
+	for name, w := range want {
+		field, _ := format.Field(name)
+		v, err := field.Decode(record)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v != w {
+			t.Errorf("Field %s: got %#v, want %#v", name, v, w)
+		}
+	}
+
+	field, _ := format.Field("ptr")
+	if _, err := field.Decode(record[:30]); err == nil {
+		t.Fatal("Short record should be rejected.")
+	}
+	field, _ = format.Field("filename")
+	nativeEndian.PutUint32(record[8:], 16<<16|32)
+	if _, err := field.Decode(record); err == nil {
+		t.Fatal("Dynamic data past the record should be rejected.")
+	}
+}
+
+func TestAttachTracepoint(t *testing.T) {
+	if _, err := tracefsPath(); errors.Is(err, ErrNoTracefs) {
+		t.Skip("tracefs isn't mounted.")
+	}
+
+	format, err := ReadTracepointFormat("sched", "sched_switch")
+	if errors.Is(err, os.ErrNotExist) {
+		t.Skip("Kernel doesn't have the sched_switch tracepoint.")
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := format.Field("next_pid"); !ok {
+		t.Fatal("sched_switch should have a next_pid field:", format.Fields)
+	}
+
+	prog, counter := loadTestCounterProg(t, "tracepoint", ProgTypeTracepoint)
+	defer prog.Close()
+
+	link, err := AttachTracepoint("sched", "sched_switch", prog)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Sleeping makes the scheduler switch tasks, but sched_switch may take a moment to fire after attaching.
+	for i := 0; i < 100 && readTestCounter(t, counter) == 0; i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if readTestCounter(t, counter) == 0 {
+		t.Fatal("The program didn't run on sched_switch.")
+	}
+
+	if err := link.Close(); err != nil {
+		t.Fatal(err)
+	}
+	count := readTestCounter(t, counter)
+	time.Sleep(50 * time.Millisecond)
+	if after := readTestCounter(t, counter); after != count {
+		t.Fatal("The program still ran after the link was closed:", count, after)
+	}
+
+	if _, err := AttachTracepoint("sched", "no_such_tracepoint", prog); !errors.Is(err, os.ErrNotExist) {
+		t.Fatal("Attaching to a missing tracepoint should fail with ENOENT:", err)
+	}
+	if _, err := AttachTracepoint("..", "sched", prog); err == nil {
+		t.Fatal("Bad tracepoint names should be rejected.")
+	}
+}
@@ -0,0 +1,174 @@
+package bpf
+
+import (
+	"fmt"
+	"runtime"
+	"unsafe"
+)
+
+// TypedMap wraps a Map with Go types for its keys and values. Keys and values are marshalled with
+// encoding/binary in the host's byte order, so K and V must be fixed size: basic types other than int and uint,
+// arrays, and structs made of those with exported fields. C structs with holes must declare the padding
+// explicitly with blank (_) fields.
+type TypedMap[K comparable, V any] struct {
+	m *Map
+}
+
+// NewTypedMap wraps m. It fails if K or V doesn't marshal to the key and value size of m.
+func NewTypedMap[K comparable, V any](m *Map) (*TypedMap[K, V], error) {
+	keySize, err := binarySize[K]()
+	if err != nil {
+		return nil, err
+	}
+	if keySize != int(m.KeySize) {
+		return nil, fmt.Errorf("Map %s has %d byte keys but the key type is %d bytes", m.Name, m.KeySize, keySize)
+	}
+
+	valueSize, err := binarySize[V]()
+	if err != nil {
+		return nil, err
+	}
+	if valueSize != int(m.ValueSize) {
+		return nil, fmt.Errorf("Map %s has %d byte values but the value type is %d bytes", m.Name, m.ValueSize,
+			valueSize)
+	}
+
+	return &TypedMap[K, V]{m: m}, nil
+}
+
+// Map returns the underlying map.
+func (t *TypedMap[K, V]) Map() *Map {
+	return t.m
+}
+
+// Lookup returns the value of key. The bool is false if key isn't present. Use LookupPerCPU for per-CPU maps.
+func (t *TypedMap[K, V]) Lookup(key K) (V, bool, error) {
+	var value V
+
+	if t.m.isPerCPU() {
+		return value, false, errPerCPU
+	}
+
+	valueBuf, found, err := t.lookupBuf(key)
+	if err != nil || !found {
+		return value, false, err
+	}
+
+	if err := unmarshalBinary(valueBuf, &value); err != nil {
+		return value, false, err
+	}
+
+	return value, true, nil
+}
+
+// Put sets the value of key. See BpfMapUpdateElem for flags. Use PutPerCPU for per-CPU maps.
+func (t *TypedMap[K, V]) Put(key K, value V, flags uint32) error {
+	if t.m.isPerCPU() {
+		return errPerCPU
+	}
+
+	valueBuf, err := marshalBinary(&value, int(t.m.ValueSize))
+	if err != nil {
+		return err
+	}
+
+	return t.putBuf(key, valueBuf, flags)
+}
+
+// lookupBuf returns the raw value of key, sized for per-CPU maps where necessary.
+func (t *TypedMap[K, V]) lookupBuf(key K) ([]byte, bool, error) {
+	keyBuf, err := marshalBinary(&key, int(t.m.KeySize))
+	if err != nil {
+		return nil, false, err
+	}
+
+	bufSize, err := t.m.valueBufSize()
+	if err != nil {
+		return nil, false, err
+	}
+	valueBuf := make([]byte, bufSize)
+
+	found, err := bpfMapLookupElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(t.m)
+	if err != nil || !found {
+		return nil, false, withName(err, t.m.Name)
+	}
+
+	return valueBuf, true, nil
+}
+
+// putBuf sets the raw value of key.
+func (t *TypedMap[K, V]) putBuf(key K, valueBuf []byte, flags uint32) error {
+	keyBuf, err := marshalBinary(&key, int(t.m.KeySize))
+	if err != nil {
+		return err
+	}
+
+	err = bpfMapUpdateElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])), bpfPtr(unsafe.Pointer(&valueBuf[0])), flags)
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(valueBuf)
+	runtime.KeepAlive(t.m)
+
+	return withName(err, t.m.Name)
+}
+
+// Delete removes key. It returns false if key wasn't present.
+func (t *TypedMap[K, V]) Delete(key K) (bool, error) {
+	keyBuf, err := marshalBinary(&key, int(t.m.KeySize))
+	if err != nil {
+		return false, err
+	}
+
+	deleted, err := bpfMapDeleteElem(t.m.fd, bpfPtr(unsafe.Pointer(&keyBuf[0])))
+	runtime.KeepAlive(keyBuf)
+	runtime.KeepAlive(t.m)
+
+	return deleted, withName(err, t.m.Name)
+}
+
+// All returns a snapshot of every key and value in the map. Elements that are deleted while the snapshot is
+// being taken may be missing. See MapIterator. Use AllPerCPU for per-CPU maps.
+func (t *TypedMap[K, V]) All() (map[K]V, error) {
+	if t.m.isPerCPU() {
+		return nil, errPerCPU
+	}
+
+	all := make(map[K]V)
+
+	var key K
+	var value V
+
+	it := t.m.Iterate()
+	for it.Next(&key, &value) {
+		all[key] = value
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
+
+// AllPerCPU is like All for per-CPU maps. Each value has one entry per possible CPU.
+func (t *TypedMap[K, V]) AllPerCPU() (map[K][]V, error) {
+	if !t.m.isPerCPU() {
+		return nil, fmt.Errorf("Map %s isn't a per-CPU map", t.m.Name)
+	}
+
+	all := make(map[K][]V)
+
+	var key K
+	var values []V
+
+	it := t.m.Iterate()
+	for it.Next(&key, &values) {
+		all[key] = values
+	}
+	if err := it.Err(); err != nil {
+		return nil, err
+	}
+
+	return all, nil
+}
@@ -0,0 +1,107 @@
+package bpf
+
+import (
+	"testing"
+)
+
+// typedKey and typedValue need to match struct map_key and struct map_entry in bpf/simple_map.c.
+type typedKey struct {
+	A uint32
+	B uint32
+}
+
+type typedValue struct {
+	ValueA uint64
+	ValueB uint64
+}
+
+// paddedValue has the layout of a C struct { __u32 a; __u64 b; } with the hole declared explicitly.
+type paddedValue struct {
+	A uint32
+	_ [4]byte
+	B uint64
+}
+
+func TestTypedMap(t *testing.T) {
+	m, err := NewMap(MapTypeHash, 8, 16, 16, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	tm, err := NewTypedMap[typedKey, typedValue](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, found, err := tm.Lookup(typedKey{111, 222})
+	if err != nil || found {
+		t.Fatal("Lookup in an empty map:", found, err)
+	}
+
+	if err := tm.Put(typedKey{111, 222}, typedValue{8888, 9999}, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Put(typedKey{333, 444}, typedValue{6666, 7777}, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := tm.Lookup(typedKey{111, 222})
+	if err != nil || !found || value != (typedValue{8888, 9999}) {
+		t.Fatal("Bad lookup:", value, found, err)
+	}
+
+	// The typed map and the raw map functions must agree on the encoding.
+	myEntry := entry{}
+	found, err = m.Lookup(&key{333, 444}, &myEntry)
+	if err != nil || !found || myEntry.valueA != 6666 || myEntry.valueB != 7777 {
+		t.Fatal("Bad raw lookup:", myEntry, found, err)
+	}
+
+	all, err := tm.All()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(all) != 2 || all[typedKey{333, 444}] != (typedValue{6666, 7777}) {
+		t.Fatal("Bad map contents:", all)
+	}
+
+	deleted, err := tm.Delete(typedKey{111, 222})
+	if err != nil || !deleted {
+		t.Fatal("Delete failed:", deleted, err)
+	}
+}
+
+func TestTypedMapSizeCheck(t *testing.T) {
+	m, err := NewMap(MapTypeArray, 4, 16, 4, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Close()
+
+	if _, err := NewTypedMap[uint64, typedValue](m); err == nil {
+		t.Fatal("Key size mismatch should be rejected.")
+	}
+	if _, err := NewTypedMap[uint32, uint64](m); err == nil {
+		t.Fatal("Value size mismatch should be rejected.")
+	}
+	if _, err := NewTypedMap[uint32, []byte](m); err == nil {
+		t.Fatal("Variable size values should be rejected.")
+	}
+	if _, err := NewTypedMap[uint32, entry](m); err == nil {
+		t.Fatal("Values with unexported fields should be rejected.")
+	}
+
+	tm, err := NewTypedMap[uint32, paddedValue](m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tm.Put(3, paddedValue{A: 1, B: 2}, UpdateAny); err != nil {
+		t.Fatal(err)
+	}
+
+	value, found, err := tm.Lookup(3)
+	if err != nil || !found || value.A != 1 || value.B != 2 {
+		t.Fatal("Bad lookup:", value, found, err)
+	}
+}
@@ -0,0 +1,117 @@
+package bpf
+
+import (
+	"bytes"
+	"strconv"
+	"strings"
+
+	"golang.org/x/sys/unix"
+)
+
+// Default and maximum sizes of the verifier log buffer. Kernels before 5.2 reject log buffers of 16 MiB or more.
+const bpfVerifierLogSizeDefault = 64 * 1024
+const bpfVerifierLogSizeMax = (1 << 24) - 1
+
+// LogLevel is the verifier log level passed to BPF_PROG_LOAD. The levels are flags and may be combined, e.g.
+// LogLevelInstruction | LogLevelStats.
+type LogLevel uint32
+
+const (
+	LogLevelNone        LogLevel = 0 // No log.
+	LogLevelInstruction LogLevel = 1 // The instructions and register state along the paths the verifier walks.
+	LogLevelBranch      LogLevel = 2 // Like LogLevelInstruction but with the state at every instruction.
+	LogLevelStats       LogLevel = 4 // Verification statistics only (kernel 5.2 and later).
+)
+
+// VerifierError is returned when the kernel refuses to load a program.
+type VerifierError struct {
+	Section   string     // ELF section of the program that failed to load.
+	Errno     unix.Errno // The errno returned by BPF_PROG_LOAD.
+	Log       []string   // The verifier log split into lines. Empty if the program was loaded without a log.
+	Truncated bool       // True if the log didn't fit into the largest buffer the loader tries.
+}
+
+// newVerifierError builds a VerifierError from the raw log buffer, which the kernel NUL terminates.
+func newVerifierError(errno unix.Errno, logBuf []byte, truncated bool) *VerifierError {
+	if i := bytes.IndexByte(logBuf, 0); i >= 0 {
+		logBuf = logBuf[:i]
+	}
+
+	return &VerifierError{
+		Errno:     errno,
+		Log:       splitVerifierLog(string(logBuf)),
+		Truncated: truncated,
+	}
+}
+
+// splitVerifierLog splits the verifier log into lines, dropping the trailing empty line.
+func splitVerifierLog(log string) []string {
+	log = strings.TrimRight(log, "\n")
+	if log == "" {
+		return nil
+	}
+
+	return strings.Split(log, "\n")
+}
+
+func (e *VerifierError) Error() string {
+	msg := bpfCmdProgLoad.String() + " " + e.Section + ": " + e.Errno.Error()
+
+	// The last line of the log is usually the reason the program was rejected.
+	for i := len(e.Log) - 1; i >= 0; i-- {
+		if line := strings.TrimSpace(e.Log[i]); line != "" {
+			msg += ": " + line
+			break
+		}
+	}
+
+	if e.Truncated {
+		msg += " (verifier log truncated)"
+	}
+
+	return msg
+}
+
+// Unwrap returns the underlying *Error so that errors.Is works with the errno.
+func (e *VerifierError) Unwrap() error {
+	return &Error{Cmd: bpfCmdProgLoad, Errno: e.Errno, FD: -1, Name: e.Section}
+}
+
+// String returns the whole verifier log.
+func (e *VerifierError) String() string {
+	return strings.Join(e.Log, "\n")
+}
+
+// VerifierLine is a line of the verifier log that refers to an instruction.
+type VerifierLine struct {
+	Insn int    // Index of the instruction.
+	Text string // The rest of the line, e.g. "(85) call bpf_map_lookup_elem#1".
+}
+
+// InstructionLines returns the lines of the log that show an instruction ("12: (85) call ..."). Register state
+// dumps, which also start with an instruction index ("12: R1=ctx() R10=fp0"), and summary lines are skipped.
+func (e *VerifierError) InstructionLines() []VerifierLine {
+	var lines []VerifierLine
+
+	for _, line := range e.Log {
+		colon := strings.IndexByte(line, ':')
+		if colon <= 0 {
+			continue
+		}
+
+		insn, err := strconv.Atoi(line[:colon])
+		if err != nil {
+			continue
+		}
+
+		// The instruction itself is printed as its opcode in parentheses, followed by the disassembly.
+		text := strings.TrimSpace(line[colon+1:])
+		if !strings.HasPrefix(text, "(") {
+			continue
+		}
+
+		lines = append(lines, VerifierLine{Insn: insn, Text: text})
+	}
+
+	return lines
+}
@@ -0,0 +1,93 @@
+package bpf
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestVerifierErrorParse(t *testing.T) {
+	logBuf := make([]byte, 256)
+	copy(logBuf, "func#0 @0\n0: R1=ctx(off=0,imm=0) R10=fp0\n0: (95) exit\nR0 !read_ok\nprocessed 1 insns\n")
+
+	verr := newVerifierError(unix.EACCES, logBuf, false)
+	verr.Section = "classifier"
+
+	if len(verr.Log) != 5 {
+		t.Fatal("Wrong number of log lines:", len(verr.Log), verr.Log)
+	}
+
+	insnLines := verr.InstructionLines()
+	if len(insnLines) != 1 || insnLines[0].Insn != 0 || insnLines[0].Text != "(95) exit" {
+		t.Fatal("Bad instruction lines:", insnLines)
+	}
+
+	if !strings.HasSuffix(verr.Error(), ": processed 1 insns") {
+		t.Fatal("Error should end with the last log line:", verr.Error())
+	}
+	if !errors.Is(verr, unix.EACCES) {
+		t.Fatal("VerifierError should match its errno.")
+	}
+}
+
+func TestVerifierErrorFromKernel(t *testing.T) {
+	// Exiting without setting R0 is rejected by the verifier.
+	insns := []bpfInsn{insnExit}
+
+	spec := bpfProgSpec{progType: ProgTypeSchedCls, insns: insns, license: testLicense}
+
+	_, verr := bpfProgLoad(&spec, LogLevelInstruction, 0)
+	if verr == nil {
+		t.Fatal("Program should have been rejected.")
+	}
+	if verr.Truncated {
+		t.Fatal("Log shouldn't be truncated.")
+	}
+	if !strings.Contains(verr.String(), "R0 !read_ok") {
+		t.Fatal("Unexpected verifier log:", verr.String())
+	}
+	if strings.ContainsRune(verr.String(), 0) {
+		t.Fatal("Log should be trimmed at the NUL terminator.")
+	}
+}
+
+func TestVerifierLogGrows(t *testing.T) {
+	insns := make([]bpfInsn, 0, 101)
+	for i := 0; i < 100; i++ {
+		insns = append(insns, insnMovR0Imm0)
+	}
+	insns = append(insns, insnExit)
+
+	// A 128 byte buffer is far too small for the log so the load must be retried with bigger buffers.
+	spec := bpfProgSpec{progType: ProgTypeSchedCls, insns: insns, license: testLicense}
+
+	fd, verr := bpfProgLoad(&spec, LogLevelBranch, 128)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+	unix.Close(fd)
+}
+
+func TestLogOnFailure(t *testing.T) {
+	opts := LoadOptions{LogOnFailure: true}
+
+	spec := bpfProgSpec{progType: ProgTypeSchedCls, insns: []bpfInsn{insnMovR0Imm0, insnExit}, license: testLicense}
+
+	fd, verr := bpfProgLoadWithOptions(&spec, &opts)
+	if verr != nil {
+		t.Fatal(verr)
+	}
+	unix.Close(fd)
+
+	spec.insns = []bpfInsn{insnExit}
+
+	_, verr = bpfProgLoadWithOptions(&spec, &opts)
+	if verr == nil {
+		t.Fatal("Program should have been rejected.")
+	}
+	if len(verr.Log) == 0 {
+		t.Fatal("The failed load should have been retried with the log on.")
+	}
+}
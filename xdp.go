@@ -0,0 +1,99 @@
+package bpf
+
+import (
+	"errors"
+
+	"golang.org/x/sys/unix"
+)
+
+// Flags for AttachXDP, DetachXDP and XDPProgID. At most one of the mode flags may be set; without one the kernel
+// uses driver mode if the driver supports it and generic mode otherwise.
+const (
+	XDPFlagsUpdateIfNoExist = unix.XDP_FLAGS_UPDATE_IF_NOEXIST // Fail if a program is already attached.
+	XDPFlagsGenericMode     = unix.XDP_FLAGS_SKB_MODE          // Run on the sk_buff after the driver (any interface).
+	XDPFlagsDriverMode      = unix.XDP_FLAGS_DRV_MODE          // Run in the driver before an sk_buff is allocated.
+	XDPFlagsOffloadMode     = unix.XDP_FLAGS_HW_MODE           // Run on the NIC.
+
+	xdpFlagsModes = XDPFlagsGenericMode | XDPFlagsDriverMode | XDPFlagsOffloadMode
+)
+
+// AttachXDP attaches prog, which must be a ProgTypeXdp program, to the interface, replacing the program attached
+// in the same mode unless flags has XDPFlagsUpdateIfNoExist, in which case the error matches unix.EBUSY.
+func AttachXDP(ifindex int, prog *Program, flags uint32) error {
+	return xdpSetLink("attach xdp", ifindex, prog.fd, flags)
+}
+
+// DetachXDP detaches the XDP program attached to the interface in the mode set in flags.
+func DetachXDP(ifindex int, flags uint32) error {
+	return xdpSetLink("detach xdp", ifindex, -1, flags&xdpFlagsModes)
+}
+
+// XDPProgID returns the id of the XDP program attached to the interface in the mode set in flags, or zero if
+// there is none. Without a mode flag it returns the id of the only attached program, which is zero if programs
+// are attached in more than one mode.
+func XDPProgID(ifindex int, flags uint32) (uint32, error) {
+	conn, err := dialNetlink()
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+
+	replies, err := conn.request("get link", unix.RTM_GETLINK, 0, ifInfoMsg(ifindex))
+	if err != nil {
+		return 0, err
+	}
+	if len(replies) != 1 || len(replies[0]) < unix.SizeofIfInfomsg {
+		return 0, errors.New("Bad RTM_GETLINK reply")
+	}
+
+	attrs := parseNetlinkAttrs(replies[0][unix.SizeofIfInfomsg:])
+	xdp := parseNetlinkAttrs(attrs[unix.IFLA_XDP])
+
+	var idAttr uint16
+	switch flags & xdpFlagsModes {
+	case XDPFlagsGenericMode:
+		idAttr = unix.IFLA_XDP_SKB_PROG_ID
+	case XDPFlagsDriverMode:
+		idAttr = unix.IFLA_XDP_DRV_PROG_ID
+	case XDPFlagsOffloadMode:
+		idAttr = unix.IFLA_XDP_HW_PROG_ID
+	default:
+		idAttr = unix.IFLA_XDP_PROG_ID
+	}
+
+	if id := xdp[idAttr]; len(id) == 4 {
+		return nativeEndian.Uint32(id), nil
+	}
+
+	return 0, nil
+}
+
+// xdpSetLink sends RTM_SETLINK with IFLA_XDP to attach progFd, or detach with -1.
+func xdpSetLink(op string, ifindex int, progFd int, flags uint32) error {
+	xdp := netlinkAttrs{}
+	xdp.addU32(unix.IFLA_XDP_FD, uint32(int32(progFd)))
+	if flags != 0 {
+		xdp.addU32(unix.IFLA_XDP_FLAGS, flags)
+	}
+
+	attrs := netlinkAttrs{}
+	attrs.addNested(unix.IFLA_XDP, &xdp)
+
+	conn, err := dialNetlink()
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	_, err = conn.request(op, unix.RTM_SETLINK, 0, append(ifInfoMsg(ifindex), attrs.buf...))
+	return err
+}
+
+// ifInfoMsg encodes a struct ifinfomsg for the interface.
+func ifInfoMsg(ifindex int) []byte {
+	msg := make([]byte, unix.SizeofIfInfomsg)
+	msg[0] = unix.AF_UNSPEC
+	nativeEndian.PutUint32(msg[4:], uint32(int32(ifindex)))
+
+	return msg
+}
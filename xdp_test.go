@@ -0,0 +1,60 @@
+package bpf
+
+import (
+	"errors"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestXDP(t *testing.T) {
+	ifindex := enterTestNetns(t)
+
+	prog := loadTestProg(t, "xdp_pass", ProgTypeXdp, 2)
+	defer prog.Close()
+
+	if err := AttachXDP(ifindex, prog, XDPFlagsGenericMode); err != nil {
+		t.Fatal(err)
+	}
+
+	id, err := XDPProgID(ifindex, XDPFlagsGenericMode)
+	if err != nil || id == 0 {
+		t.Fatal("Program should be attached in generic mode:", id, err)
+	}
+	if anyID, err := XDPProgID(ifindex, 0); err != nil || anyID != id {
+		t.Fatal("Bad program id without a mode:", anyID, err)
+	}
+	if drvID, err := XDPProgID(ifindex, XDPFlagsDriverMode); err != nil || drvID != 0 {
+		t.Fatal("Nothing should be attached in driver mode:", drvID, err)
+	}
+
+	// The program stays attached once its fd is closed.
+	if err := prog.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if closedID, err := XDPProgID(ifindex, XDPFlagsGenericMode); err != nil || closedID != id {
+		t.Fatal("Program should still be attached after Close:", closedID, err)
+	}
+
+	prog2 := loadTestProg(t, "xdp_pass", ProgTypeXdp, 2)
+	defer prog2.Close()
+
+	err = AttachXDP(ifindex, prog2, XDPFlagsGenericMode|XDPFlagsUpdateIfNoExist)
+	if !errors.Is(err, unix.EBUSY) {
+		t.Fatal("Attaching over an existing program with XDPFlagsUpdateIfNoExist should fail with EBUSY:", err)
+	}
+
+	if err := AttachXDP(ifindex, prog2, XDPFlagsGenericMode); err != nil {
+		t.Fatal(err)
+	}
+	if id2, err := XDPProgID(ifindex, XDPFlagsGenericMode); err != nil || id2 == id || id2 == 0 {
+		t.Fatal("Program should have been replaced:", id2, err)
+	}
+
+	if err := DetachXDP(ifindex, XDPFlagsGenericMode); err != nil {
+		t.Fatal(err)
+	}
+	if id, err := XDPProgID(ifindex, XDPFlagsGenericMode); err != nil || id != 0 {
+		t.Fatal("Program should have been detached:", id, err)
+	}
+}